@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// activityTypes are the types the Bored API knows about.
+var activityTypes = map[string]bool{
+	"education":    true,
+	"recreational": true,
+	"social":       true,
+	"diy":          true,
+	"charity":      true,
+	"cooking":      true,
+	"relaxation":   true,
+	"music":        true,
+	"busywork":     true,
+}
+
+// defaultActivityType is used by handleForm when no type is requested, from
+// DEFAULT_ACTIVITY_TYPE. Empty means a random activity.
+var defaultActivityType = loadDefaultActivityType()
+
+func loadDefaultActivityType() string {
+	t, ok := os.LookupEnv("DEFAULT_ACTIVITY_TYPE")
+	if !ok || t == "" {
+		return ""
+	}
+	if !activityTypes[t] {
+		log.Printf("Ignoring invalid DEFAULT_ACTIVITY_TYPE %q", t)
+		return ""
+	}
+	return t
+}
+
+// participantsBucket groups participant counts into a few low-cardinality
+// buckets for use as an attribute.
+func participantsBucket(n int) string {
+	switch {
+	case n <= 1:
+		return "1"
+	case n == 2:
+		return "2"
+	case n <= 4:
+		return "3-4"
+	}
+	return "5+"
+}
+
+// Upper bounds of the price tiers. The Bored API reports prices from 0 to 1.
+const (
+	cheapPriceMax    = 0.3
+	moderatePriceMax = 0.6
+)
+
+// priceTier groups an activity's price into free, cheap, moderate or
+// expensive for use as an attribute.
+func priceTier(price float32) string {
+	switch {
+	case price <= 0:
+		return "free"
+	case price <= cheapPriceMax:
+		return "cheap"
+	case price <= moderatePriceMax:
+		return "moderate"
+	}
+	return "expensive"
+}
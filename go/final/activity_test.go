@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParticipantsBucket(t *testing.T) {
+	tests := map[int]string{0: "1", 1: "1", 2: "2", 3: "3-4", 4: "3-4", 5: "5+", 8: "5+"}
+	for n, want := range tests {
+		if got := participantsBucket(n); got != want {
+			t.Errorf("participantsBucket(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestPriceTier(t *testing.T) {
+	tests := map[float32]string{
+		0:                "free",
+		0.1:              "cheap",
+		cheapPriceMax:    "cheap",
+		0.31:             "moderate",
+		moderatePriceMax: "moderate",
+		0.61:             "expensive",
+		1:                "expensive",
+	}
+	for price, want := range tests {
+		if got := priceTier(price); got != want {
+			t.Errorf("priceTier(%v) = %q, want %q", price, got, want)
+		}
+	}
+}
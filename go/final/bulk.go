@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const defaultMaxBulkBodyBytes = 64 << 10
+
+// bulkWorkers is the number of upstream fetches a bulk request runs at once.
+var bulkWorkers = bulkWorkerCount()
+
+type bulkRequest struct {
+	Types []string `json:"types"`
+}
+
+func handleBulk(c *gin.Context) {
+	var req bulkRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx := c.Request.Context()
+	workers := bulkWorkers
+	if workers > len(req.Types) {
+		workers = len(req.Types)
+	}
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Int("batch.workers", workers))
+
+	items := make([]bulkItem, len(req.Types))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				items[i] = fetchBulkItem(ctx, req.Types[i])
+			}
+		}()
+	}
+	for i := range req.Types {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	failures := 0
+	for _, item := range items {
+		if item.Status != http.StatusOK {
+			failures++
+		}
+	}
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Int("batch.failures", failures))
+	status := http.StatusOK
+	switch {
+	case failures > 0 && failures == len(items):
+		status = http.StatusInternalServerError
+	case failures > 0:
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, items)
+}
+
+// bulkItem is the outcome of one lookup in a bulk request.
+type bulkItem struct {
+	Type     string       `json:"type"`
+	Status   int          `json:"status"`
+	Activity *apiResponse `json:"activity,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+func fetchBulkItem(ctx context.Context, t string) bulkItem {
+	ctx, span := startSpan(ctx, "bulkItem")
+	defer span.End()
+	item := bulkItem{Type: t, Status: http.StatusOK}
+	activity, err := getActivityWithParams(ctx, t)
+	if err != nil {
+		item.Status = http.StatusBadGateway
+		item.Error = err.Error()
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		item.Activity = &activity
+	}
+	span.SetAttributes(
+		attribute.String("activity.type", t),
+		attribute.Int("item.status", item.Status),
+	)
+	return item
+}
+
+// bindJSON binds the request body into obj inside a bindRequest span, so
+// malformed bodies show up in traces.
+func bindJSON(c *gin.Context, obj interface{}) error {
+	_, span := startSpan(c.Request.Context(), "bindRequest")
+	defer span.End()
+	err := c.ShouldBindJSON(obj)
+	if err != nil {
+		span.SetAttributes(attribute.String("bind.error", err.Error()))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func bulkWorkerCount() int {
+	if v, ok := os.LookupEnv("BULK_WORKERS"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Ignoring invalid BULK_WORKERS %q", v)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func maxBulkBodyBytes() int64 {
+	if v, ok := os.LookupEnv("MAX_BULK_BODY_BYTES"); ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Ignoring invalid MAX_BULK_BODY_BYTES %q", v)
+	}
+	return defaultMaxBulkBodyBytes
+}
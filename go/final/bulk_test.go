@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestHandleBulkWorkerCounts(t *testing.T) {
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		activityType := req.URL.Query().Get("type")
+		return upstreamResponse(req, http.StatusOK, fmt.Sprintf(`{"activity":"Nap","type":%q,"participants":1}`, activityType)), nil
+	}))
+	defer func(old int) { bulkWorkers = old }(bulkWorkers)
+	types := []string{"bulk-a", "bulk-b", "bulk-c", "bulk-d", "bulk-e"}
+	body, _ := json.Marshal(bulkRequest{Types: types})
+
+	for _, tt := range []struct{ configured, want int }{{1, 1}, {3, 3}, {16, len(types)}} {
+		bulkWorkers = tt.configured
+		req := httptest.NewRequest(http.MethodPost, "/getActivities", strings.NewReader(string(body)))
+		w, span := serveTraced(t, req, handleBulk)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%d workers: status = %d: %s", tt.configured, w.Code, w.Body)
+		}
+		if v, _ := spanAttr(span, "batch.workers"); v.AsInt64() != int64(tt.want) {
+			t.Errorf("%d workers: batch.workers = %d, want %d", tt.configured, v.AsInt64(), tt.want)
+		}
+		var items []bulkItem
+		if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != len(types) {
+			t.Fatalf("%d workers: got %d items, want %d", tt.configured, len(items), len(types))
+		}
+		for i, item := range items {
+			if item.Type != types[i] || item.Activity == nil || item.Activity.Type != types[i] {
+				t.Errorf("%d workers: item %d = %+v, want %s", tt.configured, i, item, types[i])
+			}
+		}
+	}
+}
+
+func TestHandleBulkTracesBindErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	provider, recorder := NewTestProvider()
+	router := gin.New()
+	router.Use(otelgin.Middleware("go-server", otelgin.WithTracerProvider(provider)))
+	router.POST("/getActivities", handleBulk)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/getActivities", strings.NewReader(`{"types":`)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	bind := endedSpan(t, recorder, "bindRequest")
+	if bind.StatusCode() != codes.Error {
+		t.Errorf("bindRequest status = %v, want Error", bind.StatusCode())
+	}
+	if v, _ := spanAttr(bind, "bind.error"); v.AsString() == "" {
+		t.Error("bind.error not recorded on bindRequest")
+	}
+	server := endedSpan(t, recorder, "/getActivities")
+	if bind.Parent().SpanID != server.SpanContext().SpanID {
+		t.Errorf("bindRequest parent = %s, want the server span %s", bind.Parent().SpanID, server.SpanContext().SpanID)
+	}
+}
+
+func TestHandleBulkReportsPartialFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	provider, recorder := NewTestProvider()
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		activityType := req.URL.Query().Get("type")
+		if activityType == "partial-bad" {
+			return nil, errors.New("upstream unreachable")
+		}
+		return upstreamResponse(req, http.StatusOK, fmt.Sprintf(`{"activity":"Nap","type":%q,"participants":1}`, activityType)), nil
+	}))
+	router := gin.New()
+	router.Use(otelgin.Middleware("go-server", otelgin.WithTracerProvider(provider)))
+	router.POST("/getActivities", handleBulk)
+	types := []string{"partial-a", "partial-bad", "partial-c"}
+	body, _ := json.Marshal(bulkRequest{Types: types})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/getActivities", strings.NewReader(string(body))))
+
+	if w.Code != http.StatusMultiStatus {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusMultiStatus)
+	}
+	var items []bulkItem
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != len(types) {
+		t.Fatalf("got %d items, want %d", len(items), len(types))
+	}
+	for i, item := range items {
+		failed := types[i] == "partial-bad"
+		if failed != (item.Status != http.StatusOK) || failed != (item.Activity == nil) {
+			t.Errorf("item %d = %+v, want failed=%v", i, item, failed)
+		}
+	}
+
+	server := endedSpan(t, recorder, "/getActivities")
+	if v, _ := spanAttr(server, "batch.failures"); v.AsInt64() != 1 {
+		t.Errorf("batch.failures = %d, want 1", v.AsInt64())
+	}
+	failedItems := 0
+	for _, span := range recorder.Ended() {
+		if span.Name() != "bulkItem" {
+			continue
+		}
+		if span.Parent().SpanID != server.SpanContext().SpanID {
+			t.Errorf("bulkItem parent = %s, want the server span", span.Parent().SpanID)
+		}
+		if span.StatusCode() == codes.Error {
+			failedItems++
+			if v, _ := spanAttr(span, "activity.type"); v.AsString() != "partial-bad" {
+				t.Errorf("bulkItem for %q marked failed", v.AsString())
+			}
+		}
+	}
+	if failedItems != 1 {
+		t.Errorf("%d bulkItem spans failed, want 1", failedItems)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// activityCache holds the most recent activity for each type for a fixed
+// TTL. A zero TTL disables caching.
+type activityCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	activity apiResponse
+	expires  time.Time
+}
+
+var cache = newActivityCache(activityCacheTTL())
+
+func newActivityCache(ttl time.Duration) *activityCache {
+	return &activityCache{ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+func (c *activityCache) enabled() bool {
+	return c.ttl > 0
+}
+
+func (c *activityCache) get(t string) (apiResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[t]
+	if !ok || time.Now().After(entry.expires) {
+		return apiResponse{}, false
+	}
+	return entry.activity, true
+}
+
+// getStale returns the entry for t even if it has expired.
+func (c *activityCache) getStale(t string) (apiResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[t]
+	return entry.activity, ok
+}
+
+// remaining reports how long the cached entry for t has left to live.
+func (c *activityCache) remaining(t string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[t]
+	if !ok {
+		return 0, false
+	}
+	ttl := time.Until(entry.expires)
+	if ttl <= 0 {
+		return 0, false
+	}
+	return ttl, true
+}
+
+func (c *activityCache) set(t string, activity apiResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[t] = cacheEntry{activity: activity, expires: time.Now().Add(c.ttl)}
+}
+
+func activityCacheTTL() time.Duration {
+	if v, ok := os.LookupEnv("ACTIVITY_CACHE_TTL"); ok {
+		if ttl, err := time.ParseDuration(v); err == nil {
+			return ttl
+		}
+		log.Printf("Ignoring invalid ACTIVITY_CACHE_TTL %q", v)
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// useCache replaces the activity cache with an empty one for the rest of the
+// test.
+func useCache(t *testing.T, ttl time.Duration) {
+	t.Helper()
+	old := cache
+	cache = newActivityCache(ttl)
+	t.Cleanup(func() { cache = old })
+}
+
+func TestCacheCountsHitsAndMisses(t *testing.T) {
+	NewTestProvider()
+	useCache(t, time.Minute)
+	calls := 0
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	typeAttr := attribute.String("activity.type", "cached")
+	hits := metricSum(t, "activity.cache.hits", typeAttr)
+	misses := metricSum(t, "activity.cache.misses", typeAttr)
+
+	for i := 0; i < 2; i++ {
+		if _, err := getActivityWithParams(context.Background(), "cached"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("upstream called %d times, want 1", calls)
+	}
+	if got := metricSum(t, "activity.cache.misses", typeAttr) - misses; got != 1 {
+		t.Errorf("misses = %d, want 1", got)
+	}
+	if got := metricSum(t, "activity.cache.hits", typeAttr) - hits; got != 1 {
+		t.Errorf("hits = %d, want 1", got)
+	}
+}
+
+func TestHandleFormSetsCacheControl(t *testing.T) {
+	useCache(t, time.Minute)
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	w, span := serveTraced(t, formRequest("cooking"), handleForm)
+	// A second or so may pass between caching and computing the header.
+	header := w.Header().Get("Cache-Control")
+	if header != "public, max-age=60" && header != "public, max-age=59" {
+		t.Errorf("Cache-Control = %q, want max-age of about 60", header)
+	}
+	v, _ := spanAttr(span, "cache.control.max_age")
+	if header != fmt.Sprintf("public, max-age=%d", v.AsInt64()) {
+		t.Errorf("cache.control.max_age = %d doesn't match Cache-Control %q", v.AsInt64(), header)
+	}
+}
+
+func TestGetActivityServesStaleOnError(t *testing.T) {
+	_, recorder := NewTestProvider()
+	useCache(t, time.Nanosecond)
+	defer func(old bool) { serveStaleOnError = old }(serveStaleOnError)
+	serveStaleOnError = true
+	cache.set("stale", apiResponse{Activity: "Nap on the keyboard"})
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusBadGateway, ""), nil
+	}))
+
+	activity, err := getActivityWithParams(context.Background(), "stale")
+	if err != nil {
+		t.Fatalf("expected the stale entry, got %v", err)
+	}
+	if activity.Activity != "Nap on the keyboard" {
+		t.Errorf("activity = %q", activity.Activity)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if v, _ := spanAttr(span, "cache.stale"); !v.AsBool() {
+		t.Error("cache.stale not recorded")
+	}
+
+	serveStaleOnError = false
+	if _, err := getActivityWithParams(context.Background(), "stale"); err == nil {
+		t.Error("served a stale entry with SERVE_STALE_ON_ERROR off")
+	}
+}
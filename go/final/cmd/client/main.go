@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"final/telemetry"
+)
+
+var tracer = otel.Tracer("go-client")
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "base URL of the go-server")
+	activityType := flag.String("type", "", "activity type to request")
+	flag.Parse()
+
+	ctx := context.Background()
+	provider, err := telemetry.Init(ctx, "go-client")
+	if err != nil {
+		log.Fatalf("Failed to initialize OpenTelemetry: %v", err)
+	}
+	activity, err := getActivity(ctx, *server, *activityType)
+	if err != nil {
+		log.Printf("request failed: %v", err)
+	} else {
+		fmt.Println(activity)
+	}
+
+	// The client is short-lived, so flush any spans before exiting.
+	if err := provider.Shutdown(ctx); err != nil {
+		log.Fatalf("Failed to shut down tracer provider: %v", err)
+	}
+}
+
+func getActivity(ctx context.Context, server string, t string) (string, error) {
+	ctx, span := tracer.Start(ctx, "getActivity", oteltrace.WithAttributes(attribute.String("activityType", t)))
+	defer span.End()
+	c := http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	form := url.Values{"type": {t}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(server, "/")+"/getActivity", strings.NewReader(form.Encode()))
+	if err != nil {
+		span.AddEvent(err.Error())
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := c.Do(req)
+	if err != nil {
+		span.AddEvent(err.Error())
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		span.AddEvent(err.Error())
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status from server: %s", res.Status)
+		span.AddEvent(err.Error())
+		return "", err
+	}
+
+	return string(body), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"final/telemetry"
+)
+
+func TestGetActivityPropagatesTraceContext(t *testing.T) {
+	recorder := &telemetry.SpanRecorder{}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent, activityType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/getActivity" || r.Method != http.MethodPost {
+			t.Errorf("got %s %s, want POST /getActivity", r.Method, r.URL.Path)
+		}
+		traceparent = r.Header.Get("traceparent")
+		activityType = r.FormValue("type")
+		w.Write([]byte(`{"activity":"Learn to juggle"}`))
+	}))
+	defer server.Close()
+
+	body, err := getActivity(context.Background(), server.URL+"/", "recreational")
+	if err != nil {
+		t.Fatalf("getActivity: %v", err)
+	}
+	if body != `{"activity":"Learn to juggle"}` {
+		t.Errorf("body = %q", body)
+	}
+	if activityType != "recreational" {
+		t.Errorf("type = %q, want recreational", activityType)
+	}
+
+	var root sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "getActivity" {
+			root = s
+		}
+	}
+	if root == nil {
+		t.Fatal("no getActivity span recorded")
+	}
+	want := "00-" + root.SpanContext().TraceID.String()
+	if len(traceparent) < len(want) || traceparent[:len(want)] != want {
+		t.Errorf("traceparent = %q, want trace %s", traceparent, root.SpanContext().TraceID)
+	}
+}
+
+func TestGetActivityReportsServerErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream down", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if _, err := getActivity(context.Background(), server.URL, "social"); err == nil {
+		t.Fatal("expected an error for a 500 response")
+	}
+}
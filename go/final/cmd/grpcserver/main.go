@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+
+	"final/telemetry"
+)
+
+// The gRPC service uses a JSON codec rather than protobuf so the example
+// doesn't need generated code. Clients select it with
+// grpc.CallContentSubtype("json").
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type activityRequest struct {
+	Type string `json:"type"`
+}
+
+type activityResponse struct {
+	Activity      string  `json:"activity"`
+	Accessibility float32 `json:"accessibility"`
+	Type          string  `json:"type"`
+	Participants  int     `json:"participants"`
+	Price         float32 `json:"price"`
+}
+
+type activityServer interface {
+	GetActivity(context.Context, *activityRequest) (*activityResponse, error)
+}
+
+// grpcActivityServer answers GetActivity by calling the go-server's
+// /getActivity endpoint, so the RPC's server span and the HTTP request it
+// makes end up in the same trace.
+type grpcActivityServer struct {
+	server string
+	client *http.Client
+}
+
+func (s grpcActivityServer) GetActivity(ctx context.Context, req *activityRequest) (*activityResponse, error) {
+	form := url.Values{"type": {req.Type}}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.server, "/")+"/getActivity", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, status.Error(codes.Unavailable, fmt.Sprintf("unexpected status from server: %s", res.Status))
+	}
+	var activity activityResponse
+	if err := json.NewDecoder(res.Body).Decode(&activity); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &activity, nil
+}
+
+var activityServiceDesc = grpc.ServiceDesc{
+	ServiceName: "activity.ActivityService",
+	HandlerType: (*activityServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetActivity", Handler: getActivityHandler},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func getActivityHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(activityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(activityServer).GetActivity(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/activity.ActivityService/GetActivity"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(activityServer).GetActivity(ctx, req.(*activityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func newGRPCServer(server string) *grpc.Server {
+	s := grpc.NewServer(grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()))
+	s.RegisterService(&activityServiceDesc, grpcActivityServer{
+		server: server,
+		client: &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: 10 * time.Second},
+	})
+	return s
+}
+
+func main() {
+	listen := flag.String("listen", ":9090", "address to serve gRPC on")
+	server := flag.String("server", "http://localhost:8080", "base URL of the go-server")
+	flag.Parse()
+
+	ctx := context.Background()
+	provider, err := telemetry.Init(ctx, "go-grpcserver")
+	if err != nil {
+		log.Fatalf("Failed to initialize OpenTelemetry: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+	grpcServer := newGRPCServer(*server)
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	grpcServer.GracefulStop()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down tracer provider: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"final/telemetry"
+)
+
+func TestGetActivityRecordsServerSpan(t *testing.T) {
+	recorder := &telemetry.SpanRecorder{}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		if got := r.FormValue("type"); got != "recreational" {
+			t.Errorf("type = %q, want recreational", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"activity":"Go for a walk","type":"recreational","participants":1}`))
+	}))
+	defer backend.Close()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(backend.URL)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var resp activityResponse
+	err = conn.Invoke(context.Background(), "/activity.ActivityService/GetActivity",
+		&activityRequest{Type: "recreational"}, &resp, grpc.CallContentSubtype("json"))
+	if err != nil {
+		t.Fatalf("GetActivity: %v", err)
+	}
+	if resp.Activity != "Go for a walk" {
+		t.Errorf("activity = %q, want %q", resp.Activity, "Go for a walk")
+	}
+
+	var server sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.SpanKind() == trace.SpanKindServer {
+			server = s
+		}
+	}
+	if server == nil {
+		t.Fatal("no server span recorded")
+	}
+	if server.Name() != "activity.ActivityService/GetActivity" {
+		t.Errorf("server span name = %q", server.Name())
+	}
+	if traceparent == "" {
+		t.Error("upstream request carried no traceparent")
+	}
+}
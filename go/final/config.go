@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"gopkg.in/yaml.v3"
+
+	"final/telemetry"
+)
+
+// otelConfig is the telemetry configuration for the server. It's read from
+// the file named by OTEL_CONFIG_FILE, if any, and then overridden by
+// environment variables.
+type otelConfig struct {
+	ServiceName        string            `yaml:"service_name"`
+	ResourceAttributes map[string]string `yaml:"resource_attributes"`
+	Exporter           struct {
+		Name     string        `yaml:"name"`
+		Protocol string        `yaml:"protocol"`
+		Endpoint string        `yaml:"endpoint"`
+		Path     string        `yaml:"path"`
+		Timeout  time.Duration `yaml:"timeout"`
+	} `yaml:"exporter"`
+	Sampler struct {
+		Name   string  `yaml:"name"`
+		Arg    float64 `yaml:"arg"`
+		Warmup int     `yaml:"warmup"`
+	} `yaml:"sampler"`
+	SpanProcessor        string             `yaml:"span_processor"`
+	Trace404             bool               `yaml:"trace_404"`
+	SelfTest             bool               `yaml:"selftest"`
+	DebugSampling        bool               `yaml:"debug_sampling"`
+	ActivitySampleRatios map[string]float64 `yaml:"activity_sample_ratios"`
+	Propagators          []string           `yaml:"propagators"`
+	KeepErrors           bool               `yaml:"keep_errors"`
+	DropOrphans          bool               `yaml:"drop_orphans"`
+	ValidateSemconv      bool               `yaml:"validate_semconv"`
+}
+
+func loadConfig() (otelConfig, error) {
+	cfg := otelConfig{ServiceName: "go-server"}
+	cfg.Exporter.Name = "otlp"
+	cfg.Exporter.Protocol = "grpc"
+	cfg.Exporter.Path = "traces.jsonl"
+	cfg.Exporter.Timeout = 10 * time.Second
+	cfg.Sampler.Name = "always_on"
+	cfg.Sampler.Arg = 1.0
+	cfg.SpanProcessor = "batch"
+	cfg.SelfTest = true
+	cfg.ActivitySampleRatios = map[string]float64{"charity": 1.0}
+	cfg.Propagators = []string{"tracecontext", "baggage"}
+
+	if path, ok := os.LookupEnv("OTEL_CONFIG_FILE"); ok {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	if name, ok := os.LookupEnv("OTEL_SERVICE_NAME"); ok {
+		cfg.ServiceName = name
+	}
+	if attrs, ok := os.LookupEnv("OTEL_RESOURCE_ATTRIBUTES"); ok {
+		if cfg.ResourceAttributes == nil {
+			cfg.ResourceAttributes = map[string]string{}
+		}
+		for _, pair := range strings.Split(attrs, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return cfg, fmt.Errorf("invalid OTEL_RESOURCE_ATTRIBUTES entry %q", pair)
+			}
+			cfg.ResourceAttributes[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	if exporter, ok := os.LookupEnv("OTEL_TRACES_EXPORTER"); ok {
+		cfg.Exporter.Name = exporter
+	}
+	if protocol, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_PROTOCOL"); ok {
+		cfg.Exporter.Protocol = protocol
+	}
+	if endpoint, ok := otlpEndpointFromEnv(cfg.Exporter.Protocol); ok {
+		cfg.Exporter.Endpoint = endpoint
+	}
+	if timeout, ok := otlpTimeoutFromEnv(); ok {
+		ms, err := strconv.Atoi(timeout)
+		if err != nil || ms <= 0 {
+			return cfg, fmt.Errorf("invalid OTLP exporter timeout %q", timeout)
+		}
+		cfg.Exporter.Timeout = time.Duration(ms) * time.Millisecond
+	}
+	if path, ok := os.LookupEnv("OTEL_FILE_PATH"); ok {
+		cfg.Exporter.Path = path
+	}
+	if sampler, ok := os.LookupEnv("OTEL_TRACES_SAMPLER"); ok {
+		cfg.Sampler.Name = sampler
+	}
+	if arg, ok := os.LookupEnv("OTEL_TRACES_SAMPLER_ARG"); ok {
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q: %w", arg, err)
+		}
+		cfg.Sampler.Arg = ratio
+	}
+	if warmup, ok := os.LookupEnv("OTEL_TRACES_SAMPLER_WARMUP"); ok {
+		n, err := strconv.Atoi(warmup)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_WARMUP %q: %w", warmup, err)
+		}
+		cfg.Sampler.Warmup = n
+	}
+
+	if processor, ok := os.LookupEnv("OTEL_SPAN_PROCESSOR"); ok {
+		cfg.SpanProcessor = processor
+	}
+	if err := lookupBool("TRACE_404", &cfg.Trace404); err != nil {
+		return cfg, err
+	}
+	if err := lookupBool("OTEL_SELFTEST", &cfg.SelfTest); err != nil {
+		return cfg, err
+	}
+	if err := lookupBool("DEBUG_SAMPLING", &cfg.DebugSampling); err != nil {
+		return cfg, err
+	}
+	if ratios, ok := os.LookupEnv("ACTIVITY_SAMPLE_RATIOS"); ok {
+		cfg.ActivitySampleRatios = map[string]float64{}
+		for _, pair := range strings.Split(ratios, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return cfg, fmt.Errorf("invalid ACTIVITY_SAMPLE_RATIOS entry %q", pair)
+			}
+			ratio, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid ACTIVITY_SAMPLE_RATIOS entry %q: %w", pair, err)
+			}
+			cfg.ActivitySampleRatios[strings.TrimSpace(kv[0])] = ratio
+		}
+	}
+
+	if err := lookupBool("KEEP_ERROR_SPANS", &cfg.KeepErrors); err != nil {
+		return cfg, err
+	}
+	if err := lookupBool("DROP_ORPHAN_SPANS", &cfg.DropOrphans); err != nil {
+		return cfg, err
+	}
+	if err := lookupBool("OTEL_VALIDATE_SEMCONV", &cfg.ValidateSemconv); err != nil {
+		return cfg, err
+	}
+	if propagators, ok := os.LookupEnv("OTEL_PROPAGATORS"); ok {
+		cfg.Propagators = strings.Split(propagators, ",")
+	}
+
+	return cfg, nil
+}
+
+// lookupBool sets *dst from the named environment variable if it's set.
+func lookupBool(name string, dst *bool) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	*dst = b
+	return nil
+}
+
+// otlpEndpointFromEnv resolves the traces endpoint, preferring the
+// traces-specific variable, then the generic OTLP one, then the tutorial's
+// own COLLECTOR_ENDPOINT. The generic variable is a base URL, so for HTTP
+// the signal path is appended to it.
+func otlpEndpointFromEnv(protocol string) (string, bool) {
+	if endpoint, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); ok {
+		return endpoint, true
+	}
+	if endpoint, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_ENDPOINT"); ok {
+		if protocol != "grpc" {
+			endpoint = appendTracesPath(endpoint)
+		}
+		return endpoint, true
+	}
+	if endpoint, ok := os.LookupEnv("COLLECTOR_ENDPOINT"); ok {
+		return endpoint, true
+	}
+	return "", false
+}
+
+// appendTracesPath adds the traces signal path to a base OTLP/HTTP URL.
+// Bare host:port endpoints are treated as plain HTTP, as the OTLP/HTTP
+// driver does. Endpoints that don't parse are returned unchanged so
+// the exporter setup reports them.
+func appendTracesPath(endpoint string) string {
+	base := endpoint
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	u, err := url.Parse(base)
+	if err != nil || u.Host == "" {
+		return endpoint
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	return u.String()
+}
+
+// otlpTimeoutFromEnv returns the export timeout in milliseconds, preferring
+// the traces-specific variable.
+func otlpTimeoutFromEnv() (string, bool) {
+	if timeout, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_TRACES_TIMEOUT"); ok {
+		return timeout, true
+	}
+	return os.LookupEnv("OTEL_EXPORTER_OTLP_TIMEOUT")
+}
+
+func (cfg otelConfig) sampler() (sdktrace.Sampler, error) {
+	sampler, err := cfg.globalSampler()
+	if err != nil {
+		return nil, err
+	}
+	sampler = newActivityTypeSampler(sampler, cfg.ActivitySampleRatios)
+	if cfg.Sampler.Warmup > 0 {
+		sampler = newWarmupSampler(sampler, cfg.Sampler.Warmup)
+	}
+	sampler = decisionRecordingSampler{forcedTraceSampler{prioritySampler{sampler}}}
+	// Spans with a local parent follow its decision. Client spans started by
+	// otelhttp don't carry the request priority, and would otherwise fall
+	// through to the global sampler.
+	sampler = sdktrace.ParentBased(sampler,
+		sdktrace.WithRemoteParentSampled(sampler),
+		sdktrace.WithRemoteParentNotSampled(sampler),
+	)
+	if cfg.KeepErrors {
+		sampler = recordOnlySampler{sampler}
+	}
+	if cfg.DebugSampling {
+		sampler = loggingSampler{sampler}
+	}
+	return sampler, nil
+}
+
+// propagator builds the composite propagator from the configured names.
+// "none" turns propagation off. Unknown names are skipped; if none are left,
+// it falls back to TraceContext+Baggage rather than silently dropping
+// propagation.
+func (cfg otelConfig) propagator() propagation.TextMapPropagator {
+	var propagators []propagation.TextMapPropagator
+	for _, name := range cfg.Propagators {
+		switch strings.TrimSpace(name) {
+		case "tracecontext":
+			propagators = append(propagators, propagation.TraceContext{})
+		case "baggage":
+			propagators = append(propagators, propagation.Baggage{})
+		case "none":
+			return propagation.NewCompositeTextMapPropagator()
+		default:
+			log.Printf("Ignoring unknown propagator %q", name)
+		}
+	}
+	if len(propagators) == 0 {
+		log.Printf("No valid propagators in %q, falling back to tracecontext,baggage", cfg.Propagators)
+		return telemetry.Propagator()
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...)
+}
+
+func (cfg otelConfig) globalSampler() (sdktrace.Sampler, error) {
+	switch cfg.Sampler.Name {
+	case "always_on":
+		return sdktrace.AlwaysSample(), nil
+	case "always_off":
+		return sdktrace.NeverSample(), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(cfg.Sampler.Arg), nil
+	case "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Sampler.Arg)), nil
+	}
+	return nil, fmt.Errorf("unknown sampler %q", cfg.Sampler.Name)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "otel.yaml")
+	config := `
+service_name: cats-from-file
+resource_attributes:
+  team: felines
+  deployment.environment: staging
+sampler:
+  name: traceidratio
+  arg: 0.25
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OTEL_CONFIG_FILE", path)
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "team=tabbies")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServiceName != "cats-from-file" {
+		t.Errorf("ServiceName = %q", cfg.ServiceName)
+	}
+	if cfg.Sampler.Name != "traceidratio" || cfg.Sampler.Arg != 0.25 {
+		t.Errorf("Sampler = %+v", cfg.Sampler)
+	}
+	// Environment variables take precedence over the file.
+	if got := cfg.ResourceAttributes["team"]; got != "tabbies" {
+		t.Errorf("team = %q, want the environment's value", got)
+	}
+	if got := cfg.ResourceAttributes["deployment.environment"]; got != "staging" {
+		t.Errorf("deployment.environment = %q", got)
+	}
+	// Unset fields keep their defaults.
+	if cfg.SpanProcessor != "batch" {
+		t.Errorf("SpanProcessor = %q, want the default", cfg.SpanProcessor)
+	}
+}
+
+func TestOTLPEndpointFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		protocol string
+		want     string
+	}{
+		{"traces var wins", map[string]string{
+			"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://traces:4318/custom",
+			"OTEL_EXPORTER_OTLP_ENDPOINT":        "http://generic:4318",
+			"COLLECTOR_ENDPOINT":                 "collector:4317",
+		}, "http/protobuf", "http://traces:4318/custom"},
+		{"generic var gets the signal path over HTTP", map[string]string{
+			"OTEL_EXPORTER_OTLP_ENDPOINT": "http://generic:4318/",
+			"COLLECTOR_ENDPOINT":          "collector:4317",
+		}, "http/protobuf", "http://generic:4318/v1/traces"},
+		{"generic var without a scheme", map[string]string{
+			"OTEL_EXPORTER_OTLP_ENDPOINT": "generic:4318",
+		}, "http/protobuf", "http://generic:4318/v1/traces"},
+		{"generic var keeps its base path and query", map[string]string{
+			"OTEL_EXPORTER_OTLP_ENDPOINT": "https://generic:4318/otlp?tenant=cats",
+		}, "http/protobuf", "https://generic:4318/otlp/v1/traces?tenant=cats"},
+		{"generic var is used as is over gRPC", map[string]string{
+			"OTEL_EXPORTER_OTLP_ENDPOINT": "http://generic:4317",
+		}, "grpc", "http://generic:4317"},
+		{"collector endpoint", map[string]string{
+			"COLLECTOR_ENDPOINT": "collector:4317",
+		}, "grpc", "collector:4317"},
+		{"nothing set", nil, "grpc", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT", "COLLECTOR_ENDPOINT"} {
+				t.Setenv(name, "")
+				os.Unsetenv(name)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			got, ok := otlpEndpointFromEnv(tt.protocol)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("otlpEndpointFromEnv(%q) = %q, %v, want %q", tt.protocol, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigExporterTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default", nil, 10 * time.Second, false},
+		{"generic var", map[string]string{"OTEL_EXPORTER_OTLP_TIMEOUT": "2500"}, 2500 * time.Millisecond, false},
+		{"traces var wins", map[string]string{
+			"OTEL_EXPORTER_OTLP_TRACES_TIMEOUT": "750",
+			"OTEL_EXPORTER_OTLP_TIMEOUT":        "2500",
+		}, 750 * time.Millisecond, false},
+		{"not a number", map[string]string{"OTEL_EXPORTER_OTLP_TIMEOUT": "5s"}, 0, true},
+		{"not positive", map[string]string{"OTEL_EXPORTER_OTLP_TIMEOUT": "0"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"OTEL_EXPORTER_OTLP_TRACES_TIMEOUT", "OTEL_EXPORTER_OTLP_TIMEOUT"} {
+				t.Setenv(name, "")
+				os.Unsetenv(name)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := loadConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg.Exporter.Timeout != tt.want {
+				t.Errorf("Exporter.Timeout = %v, want %v", cfg.Exporter.Timeout, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigBoolFlags(t *testing.T) {
+	t.Setenv("TRACE_404", "1")
+	t.Setenv("KEEP_ERROR_SPANS", "TRUE")
+	t.Setenv("DROP_ORPHAN_SPANS", "false")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Trace404 || !cfg.KeepErrors || cfg.DropOrphans {
+		t.Errorf("Trace404, KeepErrors, DropOrphans = %v, %v, %v, want true, true, false", cfg.Trace404, cfg.KeepErrors, cfg.DropOrphans)
+	}
+
+	t.Setenv("OTEL_VALIDATE_SEMCONV", "yes")
+	if _, err := loadConfig(); err == nil {
+		t.Error("expected an error for OTEL_VALIDATE_SEMCONV=yes")
+	}
+}
+
+func TestPropagator(t *testing.T) {
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+	tests := []struct {
+		propagators []string
+		want        bool
+	}{
+		{[]string{"tracecontext", "baggage"}, true},
+		{[]string{"bogus"}, true},
+		{[]string{"none"}, false},
+	}
+	for _, tt := range tests {
+		cfg := otelConfig{Propagators: tt.propagators}
+		carrier := propagation.HeaderCarrier{}
+		cfg.propagator().Inject(ctx, carrier)
+		if got := carrier.Get("traceparent") != ""; got != tt.want {
+			t.Errorf("propagators %q: injected traceparent = %v, want %v", tt.propagators, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"final/telemetry"
+)
+
+type debugSpan struct {
+	Name       string                 `json:"name"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	Attributes map[string]interface{} `json:"attributes"`
+	Status     struct {
+		Code    string `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+// handleDebugSpans lists the spans the in-memory recorder has seen end, so
+// they can be inspected without running a tracing backend.
+func handleDebugSpans(recorder *telemetry.SpanRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ended := recorder.Ended()
+		spans := make([]debugSpan, 0, len(ended))
+		for _, s := range ended {
+			span := debugSpan{
+				Name:       s.Name(),
+				TraceID:    s.SpanContext().TraceID.String(),
+				SpanID:     s.SpanContext().SpanID.String(),
+				Attributes: map[string]interface{}{},
+			}
+			for _, kv := range s.Attributes() {
+				span.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+			}
+			span.Status.Code = s.StatusCode().String()
+			span.Status.Message = s.StatusMessage()
+			spans = append(spans, span)
+		}
+		c.JSON(http.StatusOK, spans)
+	}
+}
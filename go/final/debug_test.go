@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDebugSpansListsPriorRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, recorder := NewTestProvider()
+	router := newRouter(recorder)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/demo/error?code=418", nil))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/spans", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var spans []debugSpan
+	if err := json.Unmarshal(w.Body.Bytes(), &spans); err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]debugSpan{}
+	for _, span := range spans {
+		found[span.Name] = span
+	}
+	injected, ok := found["artificial.error"]
+	if !ok {
+		t.Fatalf("spans = %+v, want the artificial.error span", spans)
+	}
+	if injected.Status.Code != "Error" || injected.Attributes["error.injected"] != true {
+		t.Errorf("artificial.error = %+v", injected)
+	}
+	if server, ok := found["/demo/error"]; !ok || server.TraceID != injected.TraceID {
+		t.Errorf("server span missing or in another trace: %+v", server)
+	}
+}
+
+func TestDebugSpansNeedsRecorder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	NewTestProvider()
+	w := httptest.NewRecorder()
+	newRouter(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/spans", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 without DEBUG_SPANS", w.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const (
+	maxDemoDelay    = 10 * time.Second
+	maxChainHops    = 5
+	chainHopTimeout = 30 * time.Second
+)
+
+// handleSlow sleeps for the requested number of milliseconds, so workshops
+// have a predictably slow endpoint to look at.
+func handleSlow(c *gin.Context) {
+	ms, err := strconv.Atoi(c.DefaultQuery("ms", "500"))
+	if err != nil || ms < 0 {
+		c.String(http.StatusBadRequest, "ms must be a non-negative integer")
+		return
+	}
+	delay := time.Duration(ms) * time.Millisecond
+	if delay > maxDemoDelay {
+		delay = maxDemoDelay
+	}
+	ctx, span := startSpan(c.Request.Context(), "artificial.delay",
+		oteltrace.WithAttributes(attribute.Int64("delay.ms", delay.Milliseconds())))
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+	span.End()
+	c.JSON(http.StatusOK, gin.H{"delay_ms": delay.Milliseconds()})
+}
+
+// handleError responds with the requested error status and records a
+// matching error span.
+func handleError(c *gin.Context) {
+	code, err := strconv.Atoi(c.DefaultQuery("code", "500"))
+	if err != nil || code < 400 || code > 599 {
+		c.String(http.StatusBadRequest, "code must be an HTTP error status between 400 and 599")
+		return
+	}
+	_, span := startSpan(c.Request.Context(), "artificial.error",
+		oteltrace.WithAttributes(
+			attribute.Bool("error.injected", true),
+			attribute.Int("http.status_code", code),
+		))
+	injected := fmt.Errorf("injected %d %s", code, http.StatusText(code))
+	span.RecordError(injected)
+	span.SetStatus(codes.Error, injected.Error())
+	span.End()
+	c.String(code, injected.Error())
+}
+
+// handleChain calls itself n times, each hop a separate request carrying the
+// trace context, so a single service produces a multi-hop trace. Hops always
+// go to this server on localhost; the Host header comes from the client and
+// can't be trusted to point back here.
+func handleChain(c *gin.Context) {
+	n, err := strconv.Atoi(c.DefaultQuery("n", "3"))
+	if err != nil || n < 0 {
+		c.String(http.StatusBadRequest, "n must be a non-negative integer")
+		return
+	}
+	if n > maxChainHops {
+		n = maxChainHops
+	}
+	oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Int("chain.remaining", n))
+	if n == 0 {
+		c.JSON(http.StatusOK, gin.H{"hops": 0})
+		return
+	}
+	url := fmt.Sprintf("http://localhost:%s%s?n=%d", listenPort, c.FullPath(), n-1)
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	client := http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: chainHopTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		c.String(http.StatusBadGateway, err.Error())
+		return
+	}
+	defer res.Body.Close()
+	var next struct {
+		Hops int `json:"hops"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&next); err != nil || res.StatusCode != http.StatusOK {
+		c.String(http.StatusBadGateway, "chain hop failed: %s", res.Status)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hops": next.Hops + 1})
+}
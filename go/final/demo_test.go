@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestHandleSlowDelaysSpan(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, recorder := NewTestProvider()
+	router := gin.New()
+	router.GET("/demo/slow", handleSlow)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/demo/slow?ms=50", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	span := endedSpan(t, recorder, "artificial.delay")
+	if d := span.EndTime().Sub(span.StartTime()); d < 50*time.Millisecond {
+		t.Errorf("span lasted %s, want at least 50ms", d)
+	}
+	if v, _ := spanAttr(span, "delay.ms"); v.AsInt64() != 50 {
+		t.Errorf("delay.ms = %d, want 50", v.AsInt64())
+	}
+}
+
+func TestHandleErrorInjectsStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, recorder := NewTestProvider()
+	router := gin.New()
+	router.GET("/demo/error", handleError)
+	tests := []struct {
+		code       string
+		wantStatus int
+	}{
+		{"503", http.StatusServiceUnavailable},
+		{"200", http.StatusBadRequest},
+		{"teapot", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/demo/error?code="+tt.code, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("code=%s: status = %d, want %d", tt.code, w.Code, tt.wantStatus)
+		}
+	}
+	span := endedSpan(t, recorder, "artificial.error")
+	if span.StatusCode() != codes.Error {
+		t.Errorf("span status = %v, want Error", span.StatusCode())
+	}
+	if v, _ := spanAttr(span, "error.injected"); !v.AsBool() {
+		t.Error("error.injected not recorded")
+	}
+	if n := len(recorder.Ended()); n != 1 {
+		t.Errorf("recorded %d spans, want 1 for the valid code only", n)
+	}
+}
+
+func TestChainCallsLocalhost(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/demo/chain", handleChain)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := listenPort
+	listenPort = u.Port()
+	defer func() { listenPort = old }()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/demo/chain?n=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hops must not follow a Host header that points somewhere else.
+	req.Host = "attacker.invalid"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var body struct {
+		Hops int `json:"hops"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("status %s: %v", res.Status, err)
+	}
+	if body.Hops != 2 {
+		t.Errorf("hops = %d, want 2", body.Hops)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+)
+
+// fileExporter writes each span to a file as a single line of JSON.
+type fileExporter struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{file: f, encoder: json.NewEncoder(f)}, nil
+}
+
+func (e *fileExporter) ExportSpans(ctx context.Context, spans []*exporttrace.SpanSnapshot) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, span := range spans {
+		if err := e.encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestFileExporterWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := newFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	ctx := context.Background()
+	for _, name := range []string{"first", "second"} {
+		_, span := provider.Tracer("test").Start(ctx, name)
+		span.End()
+	}
+	if err := provider.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var span struct{ Name string }
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			t.Fatalf("line %q isn't JSON: %v", scanner.Text(), err)
+		}
+		names = append(names, span.Name)
+	}
+	if len(names) != 2 || names[0] != "first" || names[1] != "second" {
+		t.Errorf("exported spans = %q, want [first second]", names)
+	}
+}
+
+func TestInitOpenTelemetryReportsFileOpenErrors(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "file")
+	t.Setenv("OTEL_FILE_PATH", filepath.Join(t.TempDir(), "missing", "traces.jsonl"))
+	if err := InitOpenTelemetry(context.Background()); err == nil {
+		t.Fatal("expected an error for an unwritable path")
+	}
+}
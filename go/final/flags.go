@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// featureFlags maps flag keys to their variants, from FEATURE_FLAGS, e.g.
+// FEATURE_FLAGS="new-cache=on,checkout=variant-b".
+var featureFlags = loadFeatureFlags()
+
+func loadFeatureFlags() map[string]string {
+	flags := map[string]string{}
+	v, ok := os.LookupEnv("FEATURE_FLAGS")
+	if !ok {
+		return flags
+	}
+	for _, pair := range strings.Split(v, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			log.Printf("Ignoring invalid FEATURE_FLAGS entry %q", pair)
+			continue
+		}
+		flags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return flags
+}
+
+// evaluateFlag returns the variant for key, or def if it isn't set, and
+// records the result as feature_flag.<key> on the current span.
+func evaluateFlag(ctx context.Context, key, def string) string {
+	variant, ok := featureFlags[key]
+	if !ok {
+		variant = def
+	}
+	oteltrace.SpanFromContext(ctx).SetAttributes(attribute.String("feature_flag."+key, variant))
+	return variant
+}
+
+// FeatureFlagsMiddleware evaluates every configured flag against the request
+// span, so traces show which flags were active for the request.
+func FeatureFlagsMiddleware() gin.HandlerFunc {
+	keys := make([]string, 0, len(featureFlags))
+	for key := range featureFlags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return func(c *gin.Context) {
+		for _, key := range keys {
+			evaluateFlag(c.Request.Context(), key, "")
+		}
+		c.Next()
+	}
+}
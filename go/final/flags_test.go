@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestFeatureFlagsMiddleware(t *testing.T) {
+	t.Setenv("FEATURE_FLAGS", "new-cache=on, checkout = variant-b,bogus")
+	defer func(old map[string]string) { featureFlags = old }(featureFlags)
+	featureFlags = loadFeatureFlags()
+
+	var fallback string
+	_, span := serveTraced(t, httptest.NewRequest(http.MethodGet, "/", nil), FeatureFlagsMiddleware(), func(c *gin.Context) {
+		fallback = evaluateFlag(c.Request.Context(), "dark-mode", "off")
+		respondOK(c)
+	})
+
+	want := map[string]string{
+		"feature_flag.new-cache": "on",
+		"feature_flag.checkout":  "variant-b",
+		"feature_flag.dark-mode": "off",
+	}
+	for key, variant := range want {
+		if v, _ := spanAttr(span, attribute.Key(key)); v.AsString() != variant {
+			t.Errorf("%s = %q, want %q", key, v.AsString(), variant)
+		}
+	}
+	if fallback != "off" {
+		t.Errorf("evaluateFlag for an unset flag = %q, want the default %q", fallback, "off")
+	}
+	if _, found := spanAttr(span, "feature_flag.bogus"); found {
+		t.Error("recorded a flag from an invalid FEATURE_FLAGS entry")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// forcedTraces is a bounded set of trace IDs that should always be sampled.
+// Entries expire after ttl.
+type forcedTraces struct {
+	mu  sync.Mutex
+	max int
+	ttl time.Duration
+	ids map[oteltrace.TraceID]time.Time
+}
+
+var forced = newForcedTraces(100, 10*time.Minute)
+
+func newForcedTraces(max int, ttl time.Duration) *forcedTraces {
+	return &forcedTraces{max: max, ttl: ttl, ids: map[oteltrace.TraceID]time.Time{}}
+}
+
+func (f *forcedTraces) add(id oteltrace.TraceID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for existing, expires := range f.ids {
+		if now.After(expires) {
+			delete(f.ids, existing)
+		}
+	}
+	if _, ok := f.ids[id]; !ok && len(f.ids) >= f.max {
+		var oldest oteltrace.TraceID
+		var oldestExpiry time.Time
+		for existing, expires := range f.ids {
+			if oldestExpiry.IsZero() || expires.Before(oldestExpiry) {
+				oldest, oldestExpiry = existing, expires
+			}
+		}
+		delete(f.ids, oldest)
+	}
+	f.ids[id] = now.Add(f.ttl)
+}
+
+func (f *forcedTraces) contains(id oteltrace.TraceID) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	expires, ok := f.ids[id]
+	if ok && time.Now().After(expires) {
+		delete(f.ids, id)
+		return false
+	}
+	return ok
+}
+
+// forcedTraceSampler samples any span belonging to a forced trace, and defers
+// to fallback otherwise.
+type forcedTraceSampler struct {
+	fallback sdktrace.Sampler
+}
+
+func (s forcedTraceSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if forced.contains(p.TraceID) {
+		return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample}
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s forcedTraceSampler) Description() string {
+	return "ForcedTraceSampler{" + s.fallback.Description() + "}"
+}
+
+// AdminTokenMiddleware rejects requests that don't carry
+// "Authorization: Bearer <token>".
+func AdminTokenMiddleware(token string) gin.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		got := []byte(c.GetHeader("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+func handleForceTrace(c *gin.Context) {
+	id, err := oteltrace.TraceIDFromHex(c.Query("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid trace id: %v", err)
+		return
+	}
+	forced.add(id)
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id":   id.String(),
+		"expires_in": forced.ttl.String(),
+	})
+}
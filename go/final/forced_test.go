@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestForceTraceSamplesUnderAlwaysOff(t *testing.T) {
+	sampler := forcedTraceSampler{sdktrace.NeverSample()}
+	params := sdktrace.SamplingParameters{TraceID: oteltrace.TraceID{0x01, 0x31}, Name: "forced"}
+	if got := sampler.ShouldSample(params).Decision; got != sdktrace.Drop {
+		t.Fatalf("decision before forcing = %v, want Drop", got)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/admin/force-trace", AdminTokenMiddleware("s3cret"), handleForceTrace)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"valid token", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/admin/force-trace?id="+params.TraceID.String(), nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && forced.contains(params.TraceID) {
+			t.Fatalf("%s: trace was forced without a valid token", tt.name)
+		}
+	}
+
+	if got := sampler.ShouldSample(params).Decision; got != sdktrace.RecordAndSample {
+		t.Errorf("decision after forcing = %v, want RecordAndSample", got)
+	}
+}
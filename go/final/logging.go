@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// logf logs a message and, if ctx carries a recording span, also adds it to
+// the span as an event with a log.level attribute, so logs show up in traces.
+func logf(ctx context.Context, level, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("%s: %s", level, msg)
+	if span := oteltrace.SpanFromContext(ctx); span.IsRecording() {
+		span.AddEvent(msg, oteltrace.WithAttributes(attribute.String("log.level", level)))
+	}
+}
+
+func logError(ctx context.Context, format string, args ...interface{}) {
+	logf(ctx, "ERROR", format, args...)
+}
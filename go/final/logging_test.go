@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestLogErrorAddsSpanEvent(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	_, recorder := NewTestProvider()
+
+	ctx, span := tracer().Start(context.Background(), "logging")
+	logError(ctx, "litter box %s", "full")
+	span.End()
+	// Without a span there's nothing to add the event to.
+	logError(context.Background(), "no span")
+
+	events := endedSpan(t, recorder, "logging").Events()
+	if len(events) != 1 || events[0].Name != "litter box full" {
+		t.Fatalf("events = %+v, want one named %q", events, "litter box full")
+	}
+	if len(events[0].Attributes) != 1 || events[0].Attributes[0].Key != "log.level" || events[0].Attributes[0].Value.AsString() != "ERROR" {
+		t.Errorf("event attributes = %v, want log.level=ERROR", events[0].Attributes)
+	}
+}
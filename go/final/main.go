@@ -1,12 +1,23 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptrace"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -16,10 +27,75 @@ import (
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
 	oteltrace "go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"final/telemetry"
+)
+
+// tracer looks up the named tracer from the global provider on each call, so
+// spans follow whichever provider is currently installed.
+func tracer() oteltrace.Tracer {
+	return priorityTracer{otel.Tracer("go-server")}
+}
+
+// deployEnv is recorded on spans as deployment.environment, if set.
+var deployEnv = os.Getenv("DEPLOY_ENV")
+
+// startSpan starts a span with the attributes every span of ours should
+// carry: the service instance, the session ID and the deployment
+// environment. Extra attributes can be passed with oteltrace.WithAttributes.
+func startSpan(ctx context.Context, name string, opts ...oteltrace.SpanOption) (context.Context, oteltrace.Span) {
+	common := []attribute.KeyValue{semconv.ServiceInstanceIDKey.String(serviceInstanceID)}
+	if id, ok := sessionIDFromContext(ctx); ok {
+		common = append(common, sessionIDKey.String(id))
+	}
+	if deployEnv != "" {
+		common = append(common, semconv.DeploymentEnvironmentKey.String(deployEnv))
+	}
+	return tracer().Start(ctx, name, append([]oteltrace.SpanOption{oteltrace.WithAttributes(common...)}, opts...)...)
+}
+
+const (
+	maxUpstreamRetries   = 2
+	upstreamRetryBackoff = 100 * time.Millisecond
+	maxBodySnippetBytes  = 256
 )
 
-var tracer = otel.Tracer("go-server")
+var upstreamHeaderAllowlist = headerAllowlist()
+
+// ResponseTransformer, if set, post-processes each activity fetched from the
+// upstream (for example, to round prices) before it's cached and returned.
+var ResponseTransformer func(context.Context, apiResponse) apiResponse
+
+// httptraceEnabled controls whether upstream calls get spans for DNS,
+// connection and TLS phases.
+var httptraceEnabled = envBool("ENABLE_HTTPTRACE", true)
+
+// retryAttemptEvents adds an attempt.failed event for every failed upstream
+// attempt, so the whole retry history is on the span.
+var retryAttemptEvents = envBool("RETRY_ATTEMPT_EVENTS", false)
+
+// serveStaleOnError allows expired cache entries to be returned when the
+// upstream can't be reached.
+var serveStaleOnError = envBool("SERVE_STALE_ON_ERROR", false)
+
+// draining is set once the server has been asked to shut down.
+var draining atomic.Bool
+
+// listenPort is the port the server listens on, from PORT.
+var listenPort = loadListenPort()
+
+func loadListenPort() string {
+	if port, ok := os.LookupEnv("PORT"); ok {
+		return port
+	}
+	return "8080"
+}
 
 type apiResponse struct {
 	Activity      string  `json:"activity"`
@@ -31,24 +107,236 @@ type apiResponse struct {
 
 func main() {
 	ctx := context.Background()
-	InitOpenTelemetry(ctx)
+	// With DEBUG_SPANS, spans are kept in memory and served from
+	// /debug/spans instead of being exported.
+	var recorder *telemetry.SpanRecorder
+	if envBool("DEBUG_SPANS", false) {
+		var provider *sdktrace.TracerProvider
+		provider, recorder = NewTestProvider()
+		tracerShutdown = provider.Shutdown
+	} else if err := InitOpenTelemetry(ctx); err != nil {
+		log.Fatalf("Failed to initialize OpenTelemetry: %v", err)
+	}
+	stopRefresh := func() {}
+	if interval, ok := resourceRefreshInterval(); ok && recorder == nil {
+		stopRefresh = startResourceRefresh(ctx, interval)
+	}
+	if path, ok := os.LookupEnv("ACTIVITY_LOG_PATH"); ok {
+		logger, err := startActivityLogger(path)
+		if err != nil {
+			log.Fatalf("Failed to open activity log: %v", err)
+		}
+		activityLog = logger
+	}
+	srv := &http.Server{Addr: ":" + listenPort, Handler: serverHandler(newRouter(recorder))}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	drain(drainDelay())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down server: %v", err)
+	}
+	stopRefresh()
+	if err := Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down OpenTelemetry: %v", err)
+	}
+}
+
+// newRouter sets up the middleware and routes. If recorder isn't nil, the
+// spans it holds are served from /debug/spans.
+func newRouter(recorder *telemetry.SpanRecorder) *gin.Engine {
 	router := gin.New()
-	router.Use(CORSMiddleware())
-	router.Use(otelgin.Middleware("go-server"))
+	if envBool("ENABLE_CORS", true) {
+		router.Use(CORSMiddleware())
+	}
+	router.Use(PriorityMiddleware())
+	router.Use(ActivityTypeMiddleware())
+	router.Use(SessionMiddleware())
+	router.Use(otelgin.Middleware("go-server", otelgin.WithTracerProvider(stickyErrorTracerProvider{priorityTracerProvider{activityTypeTracerProvider{globalTracerProvider{}}}})))
+	if envBool("ACCESS_LOG_JSON", false) {
+		router.Use(AccessLogMiddleware())
+	}
+	if budget, ok := attributeBudget(); ok {
+		router.Use(AttributeBudgetMiddleware(budget))
+	}
+	router.Use(BaggageLimitMiddleware(maxBaggageBytes()))
+	router.Use(RequestAttributesMiddleware())
+	router.Use(GinErrorsMiddleware())
+	router.Use(QueryParamsMiddleware(queryParamAllowlist()))
+	router.Use(HandlerNameMiddleware())
+	router.Use(AcceptLanguageMiddleware())
+	router.Use(ProtocolVersionMiddleware())
+	router.Use(RouteGroupMiddleware())
+	router.Use(FeatureFlagsMiddleware())
+	router.Use(QueueTimeMiddleware())
+	router.Use(ActiveRequestsMiddleware())
+	router.Use(RequestSizeMiddleware())
+	router.Use(DeadlineMiddleware())
+	router.Use(SlowRequestMiddleware(slowRequestThreshold()))
+	router.Use(SyntheticMiddleware(syntheticConfig()))
+	if os.Getenv("DEBUG_RUNTIME") == "true" {
+		router.Use(RuntimeMiddleware())
+	}
 
 	router.GET("/", func(c *gin.Context) {
 		c.String(http.StatusOK, "hello world!")
 	})
-	router.POST("/getActivity", handleForm)
+	if envBool("ENABLE_GZIP", false) {
+		router.POST("/getActivity", GzipMiddleware(), handleForm)
+	} else {
+		router.POST("/getActivity", handleForm)
+	}
+	router.POST("/getActivities", JSONBodyMiddleware(maxBulkBodyBytes()), handleBulk)
+	router.GET("/readyz", handleReady)
+	router.GET("/trace-test", handleTraceTest)
+	router.GET("/tracestate", handleTraceState)
+	// The admin API is only served when ADMIN_TOKEN is set, and callers must
+	// present it.
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		router.POST("/admin/force-trace", AdminTokenMiddleware(token), handleForceTrace)
+	}
+	demo := router.Group("/demo")
+	demo.GET("/slow", handleSlow)
+	demo.GET("/error", handleError)
+	demo.GET("/chain", handleChain)
+	if recorder != nil {
+		router.GET("/debug/spans", handleDebugSpans(recorder))
+	}
+
+	return router
+}
+
+// serverHandler wraps router to serve HTTP/2 without TLS alongside HTTP/1.1
+// when ENABLE_H2C is set.
+func serverHandler(router http.Handler) http.Handler {
+	if envBool("ENABLE_H2C", false) {
+		return h2c.NewHandler(router, &http2.Server{})
+	}
+	return router
+}
+
+// drain fails readiness checks, then waits for delay to give load balancers
+// a chance to notice before we stop accepting connections.
+func drain(delay time.Duration) {
+	draining.Store(true)
+	log.Printf("Draining for %s before shutdown", delay)
+	time.Sleep(delay)
+}
+
+// envBool reads a boolean environment variable, returning def if it's unset
+// or invalid.
+func envBool(name string, def bool) bool {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Ignoring invalid %s %q", name, v)
+		return def
+	}
+	return b
+}
 
-	router.Run()
+func drainDelay() time.Duration {
+	if v, ok := os.LookupEnv("SHUTDOWN_DRAIN_DELAY"); ok {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Ignoring invalid SHUTDOWN_DRAIN_DELAY %q", v)
+	}
+	return 5 * time.Second
+}
+
+func maxBaggageBytes() int {
+	if v, ok := os.LookupEnv("MAX_BAGGAGE_BYTES"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("Ignoring invalid MAX_BAGGAGE_BYTES %q", v)
+	}
+	return 8192
+}
+
+// attributeBudget reads ATTRIBUTE_BUDGET, the number of distinct attributes
+// a request span may carry before it's flagged. Unset disables the check.
+func attributeBudget() (int, bool) {
+	v, ok := os.LookupEnv("ATTRIBUTE_BUDGET")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Ignoring invalid ATTRIBUTE_BUDGET %q", v)
+		return 0, false
+	}
+	return n, true
+}
+
+func syntheticConfig() (string, []string) {
+	header := "X-Synthetic"
+	if v, ok := os.LookupEnv("SYNTHETIC_HEADER"); ok {
+		header = v
+	}
+	userAgents := []string{"kube-probe", "ELB-HealthChecker", "GoogleHC", "k6/", "hey/"}
+	if v, ok := os.LookupEnv("SYNTHETIC_USER_AGENTS"); ok {
+		userAgents = strings.Split(v, ",")
+	}
+	return header, userAgents
+}
+
+func headerAllowlist() []string {
+	v, ok := os.LookupEnv("UPSTREAM_HEADER_ALLOWLIST")
+	if !ok {
+		return []string{"X-RateLimit-Remaining", "Retry-After"}
+	}
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func queryParamAllowlist() []string {
+	v, ok := os.LookupEnv("QUERY_PARAM_ALLOWLIST")
+	if !ok {
+		return []string{"type"}
+	}
+	var keys []string
+	for _, key := range strings.Split(v, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func slowRequestThreshold() time.Duration {
+	if v, ok := os.LookupEnv("SLOW_REQUEST_THRESHOLD"); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Ignoring invalid SLOW_REQUEST_THRESHOLD %q", v)
+	}
+	return 5 * time.Second
 }
 
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "traceparent, baggage, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "traceparent, tracestate, baggage, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
 
 		if c.Request.Method == "OPTIONS" {
@@ -61,44 +349,297 @@ func CORSMiddleware() gin.HandlerFunc {
 }
 
 func handleForm(c *gin.Context) {
+	parseCtx, parseSpan := startSpan(c.Request.Context(), "parseRequest")
+	body := &countingReadCloser{ReadCloser: c.Request.Body}
+	c.Request.Body = body
+	// c.PostForm parses the whole body, which can be slow for large forms.
+	_, formSpan := startSpan(parseCtx, "parseForm")
 	formType := c.PostForm("type")
+	formSpan.SetAttributes(attribute.Int("form.fields", len(c.Request.PostForm)))
+	formSpan.End()
+	parseSpan.SetAttributes(attribute.Int64("request.bytes", body.n))
+	parseSpan.End()
 	oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Bool("emptyForm", (len(formType) > 0)))
-	activity, err := getActivityWithParams(c.Request.Context(), formType)
+	if formType == "" && defaultActivityType != "" {
+		formType = defaultActivityType
+		oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Bool("activity.type_defaulted", true))
+	}
+	// otelgin keeps the extracted parent in the request context; if there
+	// wasn't a valid one, this request started a new trace.
+	isRoot := !oteltrace.RemoteSpanContextFromContext(c.Request.Context()).IsValid()
+	oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Bool("trace.is_root", isRoot))
+	start := time.Now()
+	ctx, timing := withServerTiming(c.Request.Context())
+	activity, err := getActivityWithParams(ctx, formType)
+	timing.add("total", time.Since(start))
+	c.Header("Server-Timing", timing.header())
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
+		return
 	}
+	if ttl, ok := cache.remaining(formType); ok {
+		maxAge := int64(ttl / time.Second)
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+		oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Int64("cache.control.max_age", maxAge))
+	}
+	activityLog.enqueue(c.Request.Context(), activity)
 	c.JSON(http.StatusOK, activity)
 }
 
+func handleReady(c *gin.Context) {
+	if draining.Load() {
+		c.String(http.StatusServiceUnavailable, "draining")
+		return
+	}
+	c.String(http.StatusOK, "ready")
+}
+
+// handleTraceTest reports the trace ID of the current request, so you can
+// check that tracing (and propagation from the caller) is working.
+func handleTraceTest(c *gin.Context) {
+	_, span := startSpan(c.Request.Context(), "traceTest")
+	defer span.End()
+	sc := span.SpanContext()
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id": sc.TraceID.String(),
+		"sampled":  sc.IsSampled(),
+	})
+}
+
+// handleTraceState echoes the incoming tracestate header alongside the
+// tracestate that outgoing calls from this request will carry.
+func handleTraceState(c *gin.Context) {
+	outgoing := oteltrace.SpanFromContext(c.Request.Context()).SpanContext().TraceState.String()
+	c.JSON(http.StatusOK, gin.H{
+		"incoming": c.GetHeader("tracestate"),
+		"outgoing": outgoing,
+		"members":  tracestateMembers(outgoing),
+	})
+}
+
 func getActivityWithParams(ctx context.Context, t string) (apiResponse, error) {
-	ctx, span := tracer.Start(ctx, "getActivityWithParams", oteltrace.WithAttributes(attribute.String("activityType", t)))
+	ctx, span := startSpan(ctx, "getActivityWithParams",
+		oteltrace.WithAttributes(attribute.String("activityType", t)),
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+	)
 	defer span.End()
+	// otelhttp injects this span's tracestate, inherited from the incoming
+	// request, into the upstream call.
+	span.SetAttributes(attribute.Int("tracestate.members", tracestateMembers(span.SpanContext().TraceState.String())))
+	if cache.enabled() {
+		typeAttr := attribute.String("activity.type", t)
+		if activity, ok := cache.get(t); ok {
+			cacheHits.Add(ctx, 1, typeAttr)
+			span.SetAttributes(attribute.Bool("cache.hit", true))
+			return activity, nil
+		}
+		cacheMisses.Add(ctx, 1, typeAttr)
+		span.SetAttributes(attribute.Bool("cache.hit", false))
+	}
 	activityResponse := apiResponse{}
-	url := fmt.Sprintf("https://www.boredapi.com/api/activity?type=%s", t)
-	c := http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
-	ctx = httptrace.WithClientTrace(ctx, otelhttptrace.NewClientTrace(ctx))
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		span.AddEvent(err.Error())
-		return activityResponse, err
+	base := upstreamBaseURL(t)
+	span.SetAttributes(attribute.String("upstream.service", upstreamService(base)))
+	url := fmt.Sprintf("%s/api/activity?type=%s", base, t)
+	span.SetAttributes(
+		semconv.HTTPMethodKey.String(http.MethodGet),
+		semconv.HTTPURLKey.String(sanitizeURL(url)),
+	)
+	c := http.Client{Transport: otelhttp.NewTransport(upstreamTransport)}
+	// Never record the key itself, only whether one was sent.
+	apiKey := os.Getenv("ACTIVITY_API_KEY")
+	span.SetAttributes(attribute.Bool("auth.present", apiKey != ""))
+	fetchStart := time.Now()
+	// Concurrent lookups for the same URL share a single upstream fetch. It
+	// runs detached from the caller that started it, and each caller stops
+	// waiting when its own context is done.
+	leader := false
+	ch := upstreamFlight.DoChan(url, func() (interface{}, error) {
+		leader = true
+		fetchCtx, cancel := context.WithTimeout(detachedContext{ctx}, sharedFetchTimeout)
+		defer cancel()
+		retries := 0
+		body, err := fetchActivity(fetchCtx, &c, url, apiKey)
+		for err != nil {
+			if retryAttemptEvents {
+				span.AddEvent("attempt.failed", oteltrace.WithAttributes(
+					attribute.Int("attempt.number", retries+1),
+					attribute.String("error.message", err.Error()),
+				))
+			}
+			if retries >= maxUpstreamRetries || fetchCtx.Err() != nil {
+				break
+			}
+			span.AddEvent(err.Error())
+			if !upstreamRetryBudget.take() {
+				span.SetAttributes(attribute.Bool("retry.budget_exhausted", true))
+				break
+			}
+			retries++
+			time.Sleep(time.Duration(retries) * upstreamRetryBackoff)
+			body, err = fetchActivity(fetchCtx, &c, url, apiKey)
+		}
+		return sharedFetch{body: body, retries: retries}, err
+	})
+	var body []byte
+	var err error
+	select {
+	case res := <-ch:
+		fetch, _ := res.Val.(sharedFetch)
+		body, err = fetch.body, res.Err
+		span.SetAttributes(attribute.Int("upstream.retry_count", fetch.retries))
+		// The result is sent after the fetch returns, so leader is set by now.
+		if !leader {
+			span.SetAttributes(attribute.Bool("singleflight.shared", true))
+			activityCoalesced.Add(ctx, 1, attribute.String("activity.type", t))
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
 	}
-	req.Header.Set("User-Agent", "otel-tutorial")
-	res, err := c.Do(req)
-	if err != nil {
-		span.AddEvent(err.Error())
-		return activityResponse, err
+	recordTiming(ctx, "upstream", time.Since(fetchStart))
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// The client went away; that isn't a failure on our side. A shared
+		// fetch can't be canceled by another caller, so only this caller's
+		// context is checked.
+		span.SetAttributes(attribute.Bool("request.canceled", true))
+		return activityResponse, ctx.Err()
 	}
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		span.AddEvent(err.Error())
+		if serveStaleOnError {
+			if activity, ok := cache.getStale(t); ok {
+				span.SetAttributes(attribute.Bool("cache.stale", true))
+				return activity, nil
+			}
+		}
 		return activityResponse, err
 	}
+	decodeStart := time.Now()
 	err = json.Unmarshal(body, &activityResponse)
+	recordTiming(ctx, "decode", time.Since(decodeStart))
 	if err != nil {
+		span.SetAttributes(attribute.String("response.body_snippet", bodySnippet(body)))
 		span.AddEvent(err.Error())
 		return activityResponse, err
 	}
+	activityParticipants.Record(ctx, int64(activityResponse.Participants), attribute.String("activity.type", activityResponse.Type))
+	span.SetAttributes(
+		attribute.String("activity.participants_bucket", participantsBucket(activityResponse.Participants)),
+		attribute.String("activity.price_tier", priceTier(activityResponse.Price)),
+	)
+	if t != "" {
+		span.SetAttributes(attribute.Bool("activity.type_matched", activityResponse.Type == t))
+	}
+	if ResponseTransformer != nil {
+		tctx, tspan := startSpan(ctx, "transform")
+		activityResponse = ResponseTransformer(tctx, activityResponse)
+		tspan.End()
+	}
+	if cache.enabled() {
+		cache.set(t, activityResponse)
+	}
 
 	return activityResponse, nil
 }
+
+func fetchActivity(ctx context.Context, c *http.Client, url string, apiKey string) ([]byte, error) {
+	if httptraceEnabled {
+		ctx = httptrace.WithClientTrace(ctx, otelhttptrace.NewClientTrace(ctx))
+	}
+	ctx = withDNSEvent(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "otel-tutorial")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		upstreamResponses.Add(ctx, 1, attribute.String("status_class", "error"))
+		if isDialTimeout(err) {
+			span := oteltrace.SpanFromContext(ctx)
+			span.SetAttributes(attribute.Bool("dial.timeout", true))
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	upstreamResponses.Add(ctx, 1, attribute.String("status_class", statusClass(res.StatusCode)))
+	recordResponseHeaders(oteltrace.SpanFromContext(ctx), res.Header)
+	if res.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("upstream returned %s", res.Status)
+	}
+	// The transport only decompresses responses it asked to be compressed.
+	if strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Bool("response.gzip", true))
+		return ioutil.ReadAll(gz)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// recordResponseHeaders sets allowlisted upstream response headers as span
+// attributes, using the semantic convention's lowercase, underscored names.
+func recordResponseHeaders(span oteltrace.Span, header http.Header) {
+	for _, name := range upstreamHeaderAllowlist {
+		if values := header.Values(name); len(values) > 0 {
+			key := "http.response.header." + strings.ReplaceAll(strings.ToLower(name), "-", "_")
+			span.SetAttributes(attribute.Array(key, values))
+		}
+	}
+}
+
+// withDNSEvent adds a dns.resolve event to the current span when a request
+// made with the returned context resolves its host.
+func withDNSEvent(ctx context.Context) context.Context {
+	span := oteltrace.SpanFromContext(ctx)
+	var start time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			start = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			attrs := []attribute.KeyValue{
+				attribute.Float64("dns.duration_ms", float64(time.Since(start))/float64(time.Millisecond)),
+			}
+			if info.Err != nil {
+				attrs = append(attrs, attribute.String("dns.error", info.Err.Error()))
+			}
+			span.AddEvent("dns.resolve", oteltrace.WithAttributes(attrs...))
+		},
+	})
+}
+
+func tracestateMembers(tracestate string) int {
+	if strings.TrimSpace(tracestate) == "" {
+		return 0
+	}
+	return len(strings.Split(tracestate, ","))
+}
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// bodySnippet returns a short, single-line prefix of an upstream response body
+// suitable for recording on a span.
+func bodySnippet(body []byte) string {
+	if len(body) > maxBodySnippetBytes {
+		body = body[:maxBodySnippetBytes]
+	}
+	snippet := strings.NewReplacer("\r", " ", "\n", " ").Replace(string(body))
+	return strings.ToValidUTF8(snippet, "")
+}
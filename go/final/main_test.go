@@ -0,0 +1,870 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+
+	"final/telemetry"
+)
+
+func TestMain(m *testing.M) {
+	installTestMeter()
+	os.Exit(m.Run())
+}
+
+// roundTripperFunc lets a test stand in for the upstream activity API.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// useUpstream routes upstream calls through rt for the rest of the test.
+func useUpstream(t testing.TB, rt http.RoundTripper) {
+	t.Helper()
+	old := upstreamTransport
+	upstreamTransport = rt
+	t.Cleanup(func() { upstreamTransport = old })
+}
+
+func upstreamResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:     http.StatusText(status),
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+const testActivityBody = `{"activity":"Chase a laser pointer","accessibility":0.1,"type":"recreational","participants":1,"price":0}`
+
+// endedSpan returns the last span named name that the recorder saw end.
+func endedSpan(t *testing.T, recorder *telemetry.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	ended := recorder.Ended()
+	for i := len(ended) - 1; i >= 0; i-- {
+		if ended[i].Name() == name {
+			return ended[i]
+		}
+	}
+	t.Fatalf("no %s span recorded", name)
+	return nil
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestGetActivityRetriesUpstreamErrors(t *testing.T) {
+	_, recorder := NewTestProvider()
+	calls := 0
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return upstreamResponse(req, http.StatusBadGateway, ""), nil
+		}
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+
+	activity, err := getActivityWithParams(context.Background(), "recreational")
+	if err != nil {
+		t.Fatalf("getActivityWithParams: %v", err)
+	}
+	if activity.Activity != "Chase a laser pointer" {
+		t.Errorf("activity = %q", activity.Activity)
+	}
+	if calls != 2 {
+		t.Errorf("upstream called %d times, want 2", calls)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if v, _ := spanAttr(span, "upstream.retry_count"); v.AsInt64() != 1 {
+		t.Errorf("upstream.retry_count = %d, want 1", v.AsInt64())
+	}
+}
+
+func TestGetActivityRecordsMalformedBodySnippet(t *testing.T) {
+	_, recorder := NewTestProvider()
+	body := "<html>\nupstream is down\r\n" + strings.Repeat("x", 2*maxBodySnippetBytes)
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, body), nil
+	}))
+
+	if _, err := getActivityWithParams(context.Background(), "malformed"); err == nil {
+		t.Fatal("expected a decode error")
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	v, ok := spanAttr(span, "response.body_snippet")
+	if !ok {
+		t.Fatal("response.body_snippet not recorded")
+	}
+	snippet := v.AsString()
+	if strings.ContainsAny(snippet, "\r\n") {
+		t.Errorf("snippet contains a newline: %q", snippet)
+	}
+	if len(snippet) > maxBodySnippetBytes {
+		t.Errorf("snippet is %d bytes, want at most %d", len(snippet), maxBodySnippetBytes)
+	}
+	if !strings.HasPrefix(snippet, "<html> upstream is down") {
+		t.Errorf("snippet = %q", snippet)
+	}
+}
+
+// formRequest builds a /getActivity form post for activityType.
+func formRequest(activityType string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/getActivity", strings.NewReader(url.Values{"type": {activityType}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestHandleFormSetsServerTiming(t *testing.T) {
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	w, _ := serveTraced(t, formRequest("recreational"), handleForm)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	header := w.Header().Get("Server-Timing")
+	for _, metric := range []string{"upstream;dur=", "decode;dur=", "total;dur="} {
+		if !strings.Contains(header, metric) {
+			t.Errorf("Server-Timing %q is missing %s", header, metric)
+		}
+	}
+}
+
+func TestWithDNSEventRecordsResolution(t *testing.T) {
+	provider, recorder := NewTestProvider()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	u.Host = "localhost:" + u.Port()
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "lookup")
+	req, err := http.NewRequestWithContext(withDNSEvent(ctx), http.MethodGet, u.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := (&http.Client{Transport: &http.Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	span.End()
+
+	for _, event := range endedSpan(t, recorder, "lookup").Events() {
+		if event.Name == "dns.resolve" {
+			return
+		}
+	}
+	t.Error("no dns.resolve event recorded")
+}
+
+func TestHandleFormRecordsTraceIsRoot(t *testing.T) {
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	for _, traceparent := range []string{"", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"} {
+		req := formRequest("recreational")
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		_, span := serveTraced(t, req, handleForm)
+		v, found := spanAttr(span, "trace.is_root")
+		if want := traceparent == ""; !found || v.AsBool() != want {
+			t.Errorf("traceparent %q: trace.is_root = %v (recorded %v), want %v", traceparent, v.AsBool(), found, want)
+		}
+	}
+}
+
+func TestHandleTraceTest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	old := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(old)
+	for _, sampled := range []bool{true, false} {
+		sampler := sdktrace.NeverSample()
+		if sampled {
+			sampler = sdktrace.AlwaysSample()
+		}
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sampler})))
+		router := gin.New()
+		router.GET("/trace-test", handleTraceTest)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/trace-test", nil))
+
+		var got struct {
+			TraceID string `json:"trace_id"`
+			Sampled bool   `json:"sampled"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if id, err := oteltrace.TraceIDFromHex(got.TraceID); err != nil || !id.IsValid() {
+			t.Errorf("trace_id %q isn't a valid trace ID: %v", got.TraceID, err)
+		}
+		if got.Sampled != sampled {
+			t.Errorf("%s: sampled = %v, want %v", sampler.Description(), got.Sampled, sampled)
+		}
+	}
+}
+
+func TestGetActivitySendsAPIKeyWithoutRecordingIt(t *testing.T) {
+	const key = "tuna-4-life"
+	t.Setenv("ACTIVITY_API_KEY", key)
+	_, recorder := NewTestProvider()
+	var sent string
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req.Header.Get("X-API-Key")
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	if _, err := getActivityWithParams(context.Background(), "social"); err != nil {
+		t.Fatal(err)
+	}
+	if sent != key {
+		t.Errorf("X-API-Key = %q, want %q", sent, key)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if v, _ := spanAttr(span, "auth.present"); !v.AsBool() {
+		t.Error("auth.present = false, want true")
+	}
+	for _, s := range recorder.Ended() {
+		for _, kv := range s.Attributes() {
+			if strings.Contains(kv.Value.Emit(), key) {
+				t.Errorf("span %s attribute %s contains the API key", s.Name(), kv.Key)
+			}
+		}
+	}
+}
+
+func TestHandleFormParseSpanIsChildOfServerSpan(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	provider, recorder := NewTestProvider()
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	router := gin.New()
+	router.Use(otelgin.Middleware("go-server", otelgin.WithTracerProvider(provider)))
+	router.POST("/getActivity", handleForm)
+	req := formRequest("education")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	server := endedSpan(t, recorder, "/getActivity")
+	parse := endedSpan(t, recorder, "parseRequest")
+	if parse.Parent().SpanID != server.SpanContext().SpanID {
+		t.Errorf("parseRequest parent = %s, want the server span %s", parse.Parent().SpanID, server.SpanContext().SpanID)
+	}
+	if v, _ := spanAttr(parse, "request.bytes"); v.AsInt64() != int64(len("type=education")) {
+		t.Errorf("request.bytes = %d, want %d", v.AsInt64(), len("type=education"))
+	}
+}
+
+// The upstream call goes through otelhttp, which injects the trace context
+// with the global propagator, so the activity API can continue the trace.
+func TestGetActivityPropagatesTraceContext(t *testing.T) {
+	_, recorder := NewTestProvider()
+	var traceparent string
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		traceparent = req.Header.Get("traceparent")
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	if _, err := getActivityWithParams(context.Background(), "music"); err != nil {
+		t.Fatal(err)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	want := "00-" + span.SpanContext().TraceID.String() + "-"
+	if !strings.HasPrefix(traceparent, want) {
+		t.Errorf("upstream traceparent = %q, want trace %s", traceparent, span.SpanContext().TraceID)
+	}
+}
+
+func TestGetActivityRecordsAllowlistedResponseHeaders(t *testing.T) {
+	_, recorder := NewTestProvider()
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := upstreamResponse(req, http.StatusOK, testActivityBody)
+		res.Header.Set("Retry-After", "120")
+		res.Header.Set("X-Internal-Secret", "whiskers")
+		return res, nil
+	}))
+
+	if _, err := getActivityWithParams(context.Background(), "relaxation"); err != nil {
+		t.Fatal(err)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	v, _ := spanAttr(span, "http.response.header.retry_after")
+	if got := v.AsArray(); got == nil || fmt.Sprint(got) != "[120]" {
+		t.Errorf("http.response.header.retry_after = %v, want [120]", got)
+	}
+	if _, found := spanAttr(span, "http.response.header.x_internal_secret"); found {
+		t.Error("recorded a header that isn't allowlisted")
+	}
+}
+
+func TestDrainFailsReadinessWhileRequestsFinish(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer draining.Store(false)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	router := gin.New()
+	router.GET("/readyz", handleReady)
+	router.GET("/slow", func(c *gin.Context) {
+		close(entered)
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	inFlight := make(chan int)
+	go func() {
+		res, err := http.Get(server.URL + "/slow")
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		res.Body.Close()
+		inFlight <- res.StatusCode
+	}()
+	<-entered
+
+	drain(0)
+	res, err := http.Get(server.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while draining = %d, want 503", res.StatusCode)
+	}
+
+	shutdown := make(chan error)
+	go func() { shutdown <- server.Config.Shutdown(context.Background()) }()
+	close(release)
+	if code := <-inFlight; code != http.StatusOK {
+		t.Errorf("in-flight request finished with %d, want 200", code)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+// discardExporter drops everything, so benchmarks measure the SDK and not
+// the export.
+type discardExporter struct{}
+
+func (discardExporter) ExportSpans(context.Context, []*exporttrace.SpanSnapshot) error { return nil }
+func (discardExporter) Shutdown(context.Context) error                                 { return nil }
+
+// BenchmarkGetActivity compares getActivityWithParams against the mock
+// upstream with and without tracing, to isolate instrumentation overhead.
+func BenchmarkGetActivity(b *testing.B) {
+	useUpstream(b, mockUpstream{})
+	old := otel.GetTracerProvider()
+	b.Cleanup(func() { otel.SetTracerProvider(old) })
+
+	providers := []struct {
+		name     string
+		provider oteltrace.TracerProvider
+	}{
+		{"tracing=off", oteltrace.NewNoopTracerProvider()},
+		{"tracing=on", sdktrace.NewTracerProvider(
+			sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+			sdktrace.WithSyncer(discardExporter{}),
+		)},
+	}
+	for _, p := range providers {
+		b.Run(p.name, func(b *testing.B) {
+			otel.SetTracerProvider(p.provider)
+			ctx := context.Background()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := getActivityWithParams(ctx, "relaxation"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetActivityUsesActivityRoutes(t *testing.T) {
+	_, recorder := NewTestProvider()
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(testActivityBody))
+	}))
+	defer server.Close()
+	useUpstream(t, http.DefaultTransport)
+	activityRoutes["cooking"] = server.URL + "/"
+	defer delete(activityRoutes, "cooking")
+
+	if _, err := getActivityWithParams(context.Background(), "cooking"); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/api/activity" {
+		t.Errorf("mock server got path %q", path)
+	}
+	u, _ := url.Parse(server.URL)
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if v, _ := spanAttr(span, "upstream.service"); v.AsString() != u.Host {
+		t.Errorf("upstream.service = %q, want %q", v.AsString(), u.Host)
+	}
+}
+
+func TestInstrumentationFlags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	NewTestProvider()
+	for _, enabled := range []bool{true, false} {
+		t.Setenv("ENABLE_CORS", strconv.FormatBool(enabled))
+		w := httptest.NewRecorder()
+		newRouter(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if got := w.Header().Get("Access-Control-Allow-Origin") != ""; got != enabled {
+			t.Errorf("ENABLE_CORS=%v: CORS headers set = %v", enabled, got)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testActivityBody))
+	}))
+	defer server.Close()
+	useUpstream(t, http.DefaultTransport)
+	activityRoutes["traced"] = server.URL
+	defer delete(activityRoutes, "traced")
+	defer func(old bool) { httptraceEnabled = old }(httptraceEnabled)
+	for _, enabled := range []bool{true, false} {
+		httptraceEnabled = enabled
+		_, recorder := NewTestProvider()
+		if _, err := getActivityWithParams(context.Background(), "traced"); err != nil {
+			t.Fatal(err)
+		}
+		phases := 0
+		for _, span := range recorder.Ended() {
+			if strings.HasPrefix(span.Name(), "http.") {
+				phases++
+			}
+		}
+		if got := phases > 0; got != enabled {
+			t.Errorf("ENABLE_HTTPTRACE=%v: recorded %d httptrace spans", enabled, phases)
+		}
+	}
+}
+
+func TestResponseTransformerRunsInItsOwnSpan(t *testing.T) {
+	_, recorder := NewTestProvider()
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	var transformSpan oteltrace.SpanContext
+	ResponseTransformer = func(ctx context.Context, activity apiResponse) apiResponse {
+		transformSpan = oteltrace.SpanContextFromContext(ctx)
+		activity.Activity = strings.ToUpper(activity.Activity)
+		return activity
+	}
+	defer func() { ResponseTransformer = nil }()
+
+	activity, err := getActivityWithParams(context.Background(), "charity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.Activity != "CHASE A LASER POINTER" {
+		t.Errorf("activity = %q, want the transformed value", activity.Activity)
+	}
+	span := endedSpan(t, recorder, "transform")
+	if transformSpan.SpanID != span.SpanContext().SpanID {
+		t.Error("transformer didn't run inside the transform span")
+	}
+	if parent := endedSpan(t, recorder, "getActivityWithParams"); span.Parent().SpanID != parent.SpanContext().SpanID {
+		t.Error("transform span isn't a child of getActivityWithParams")
+	}
+}
+
+func TestGetActivitySharesUpstreamFetch(t *testing.T) {
+	_, recorder := NewTestProvider()
+	var calls int32
+	started, release := make(chan struct{}), make(chan struct{})
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := getActivityWithParams(leaderCtx, "recreational")
+		leaderErr <- err
+	}()
+	<-started
+
+	type result struct {
+		activity apiResponse
+		err      error
+	}
+	follower := make(chan result, 1)
+	go func() {
+		activity, err := getActivityWithParams(context.Background(), "recreational")
+		follower <- result{activity, err}
+	}()
+	// Give the follower time to join the in-flight fetch.
+	time.Sleep(50 * time.Millisecond)
+
+	// The caller that started the fetch giving up mustn't fail the others.
+	cancelLeader()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader error = %v, want context.Canceled", err)
+	}
+	close(release)
+	res := <-follower
+	if res.err != nil {
+		t.Fatalf("follower: %v", res.err)
+	}
+	if res.activity.Activity != "Chase a laser pointer" {
+		t.Errorf("follower activity = %q", res.activity.Activity)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+
+	ended := recorder.Ended()
+	var spans []sdktrace.ReadOnlySpan
+	for _, s := range ended {
+		if s.Name() == "getActivityWithParams" {
+			spans = append(spans, s)
+		}
+	}
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d getActivityWithParams spans, want 2", len(spans))
+	}
+	leaderSpan, followerSpan := spans[0], spans[1]
+	if _, ok := spanAttr(leaderSpan, "singleflight.shared"); ok {
+		t.Error("leader span has singleflight.shared")
+	}
+	if v, _ := spanAttr(followerSpan, "singleflight.shared"); !v.AsBool() {
+		t.Error("follower span is missing singleflight.shared")
+	}
+	if _, ok := spanAttr(followerSpan, "upstream.retry_count"); !ok {
+		t.Error("follower span is missing upstream.retry_count")
+	}
+}
+
+func TestServerHandlerServesH2C(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, recorder := NewTestProvider()
+	t.Setenv("ENABLE_H2C", "true")
+	srv := httptest.NewServer(serverHandler(newRouter(nil)))
+	defer srv.Close()
+
+	// An HTTP/2 client that speaks cleartext with prior knowledge.
+	client := http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	res, err := client.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Fatalf("response protocol = %s, want HTTP/2", res.Proto)
+	}
+	span := endedSpan(t, recorder, "/")
+	if v, _ := spanAttr(span, "network.protocol.version"); v.AsString() != "2" {
+		t.Errorf("network.protocol.version = %q, want 2", v.AsString())
+	}
+}
+
+func TestStartSpanAddsCommonAttributes(t *testing.T) {
+	_, recorder := NewTestProvider()
+	defer func(old string) { deployEnv = old }(deployEnv)
+	deployEnv = "staging"
+	ctx := withSessionID(context.Background(), "whiskers-42")
+
+	_, span := startSpan(ctx, "common.attrs", oteltrace.WithAttributes(attribute.String("extra", "yes")))
+	span.End()
+	ended := endedSpan(t, recorder, "common.attrs")
+	want := map[attribute.Key]string{
+		"service.instance.id":    serviceInstanceID,
+		"session.id":             "whiskers-42",
+		"deployment.environment": "staging",
+		"extra":                  "yes",
+	}
+	for key, value := range want {
+		if v, _ := spanAttr(ended, key); v.AsString() != value {
+			t.Errorf("%s = %q, want %q", key, v.AsString(), value)
+		}
+	}
+
+	deployEnv = ""
+	_, span = startSpan(context.Background(), "common.bare")
+	span.End()
+	ended = endedSpan(t, recorder, "common.bare")
+	for _, key := range []attribute.Key{"session.id", "deployment.environment"} {
+		if _, found := spanAttr(ended, key); found {
+			t.Errorf("%s set without a value to record", key)
+		}
+	}
+}
+
+func TestGetActivityRecordsFailedAttempts(t *testing.T) {
+	_, recorder := NewTestProvider()
+	defer func(old bool) { retryAttemptEvents = old }(retryAttemptEvents)
+	retryAttemptEvents = true
+	calls := 0
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls <= 2 {
+			return upstreamResponse(req, http.StatusServiceUnavailable, ""), nil
+		}
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+
+	if _, err := getActivityWithParams(context.Background(), "attempts"); err != nil {
+		t.Fatalf("getActivityWithParams: %v", err)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	var attempts []int64
+	for _, event := range span.Events() {
+		if event.Name != "attempt.failed" {
+			continue
+		}
+		for _, a := range event.Attributes {
+			switch a.Key {
+			case "attempt.number":
+				attempts = append(attempts, a.Value.AsInt64())
+			case "error.message":
+				if !strings.Contains(a.Value.AsString(), "Service Unavailable") {
+					t.Errorf("error.message = %q, want the upstream status", a.Value.AsString())
+				}
+			}
+		}
+	}
+	if fmt.Sprint(attempts) != "[1 2]" {
+		t.Errorf("attempt.failed numbers = %v, want [1 2]", attempts)
+	}
+}
+
+func TestGetActivityRecordsTypeMismatch(t *testing.T) {
+	_, recorder := NewTestProvider()
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, `{"activity":"Knock a mug off the table","type":"busywork","participants":1}`), nil
+	}))
+
+	if _, err := getActivityWithParams(context.Background(), "mismatched"); err != nil {
+		t.Fatal(err)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	v, found := spanAttr(span, "activity.type_matched")
+	if !found || v.AsBool() {
+		t.Errorf("activity.type_matched = %v (recorded %v), want false", v.AsBool(), found)
+	}
+}
+
+func TestHandleFormUsesDefaultActivityType(t *testing.T) {
+	t.Setenv("DEFAULT_ACTIVITY_TYPE", "hairballs")
+	if got := loadDefaultActivityType(); got != "" {
+		t.Errorf("loadDefaultActivityType() = %q for a type that doesn't exist, want none", got)
+	}
+	t.Setenv("DEFAULT_ACTIVITY_TYPE", "music")
+	defer func(old string) { defaultActivityType = old }(defaultActivityType)
+	defaultActivityType = loadDefaultActivityType()
+
+	var requested string
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requested = req.URL.Query().Get("type")
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	w, span := serveTraced(t, formRequest(""), handleForm)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if requested != "music" {
+		t.Errorf("requested type %q from the upstream, want the default music", requested)
+	}
+	if v, _ := spanAttr(span, "activity.type_defaulted"); !v.AsBool() {
+		t.Error("activity.type_defaulted not set")
+	}
+}
+
+func TestGetActivityDecodesGzipResponses(t *testing.T) {
+	_, recorder := NewTestProvider()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(testActivityBody))
+		gz.Close()
+	}))
+	defer upstream.Close()
+	// With compression disabled the transport neither asks for gzip nor
+	// decompresses it, like a server that compresses unasked.
+	useUpstream(t, &http.Transport{DisableCompression: true})
+	activityRoutes["gzipped"] = upstream.URL
+	defer delete(activityRoutes, "gzipped")
+
+	activity, err := getActivityWithParams(context.Background(), "gzipped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.Activity != "Chase a laser pointer" {
+		t.Errorf("activity = %q, want the decoded upstream activity", activity.Activity)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if v, _ := spanAttr(span, "response.gzip"); !v.AsBool() {
+		t.Error("response.gzip not set")
+	}
+}
+
+func TestHandleFormRecordsFormFields(t *testing.T) {
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	formBody := url.Values{"type": {"education"}, "name": {"Mittens"}, "mood": {"grumpy"}}.Encode()
+	req := httptest.NewRequest(http.MethodPost, "/getActivity", strings.NewReader(formBody))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	gin.SetMode(gin.TestMode)
+	provider, recorder := NewTestProvider()
+	router := gin.New()
+	router.Use(otelgin.Middleware("go-server", otelgin.WithTracerProvider(provider)))
+	router.POST("/getActivity", handleForm)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	handler := endedSpan(t, recorder, "/getActivity")
+	parse := endedSpan(t, recorder, "parseRequest")
+	form := endedSpan(t, recorder, "parseForm")
+	if parse.Parent().SpanID != handler.SpanContext().SpanID {
+		t.Error("parseRequest span isn't a child of the handler span")
+	}
+	if form.Parent().SpanID != parse.SpanContext().SpanID {
+		t.Error("parseForm span isn't a child of the parseRequest span")
+	}
+	if v, _ := spanAttr(form, "form.fields"); v.AsInt64() != 3 {
+		t.Errorf("form.fields = %d, want 3", v.AsInt64())
+	}
+	if v, ok := spanAttr(parse, "request.bytes"); !ok || v.AsInt64() != int64(len(formBody)) {
+		t.Errorf("request.bytes = %d, want %d", v.AsInt64(), len(formBody))
+	}
+}
+
+func TestGetActivityForwardsTraceState(t *testing.T) {
+	_, recorder := NewTestProvider()
+	const tracestate = "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7"
+	var forwarded string
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		forwarded = req.Header.Get("tracestate")
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier{
+		"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		"Tracestate":  {tracestate},
+	})
+	if _, err := getActivityWithParams(ctx, "music"); err != nil {
+		t.Fatal(err)
+	}
+	if forwarded != tracestate {
+		t.Errorf("forwarded tracestate = %q, want %q", forwarded, tracestate)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if v, _ := spanAttr(span, "tracestate.members"); v.AsInt64() != 2 {
+		t.Errorf("tracestate.members = %d, want 2", v.AsInt64())
+	}
+}
+
+func TestRouterRecordsHandlerName(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, recorder := NewTestProvider()
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	newRouter(nil).ServeHTTP(httptest.NewRecorder(), formRequest("relaxation"))
+	span := endedSpan(t, recorder, "/getActivity")
+	if v, _ := spanAttr(span, "code.function"); v.AsString() != "handleForm" {
+		t.Errorf("code.function = %q, want handleForm", v.AsString())
+	}
+}
+
+func TestGetActivityMarksCanceledRequests(t *testing.T) {
+	_, recorder := NewTestProvider()
+	release := make(chan struct{})
+	defer close(release)
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		<-release
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := getActivityWithParams(ctx, "busywork"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if v, _ := spanAttr(span, "request.canceled"); !v.AsBool() {
+		t.Error("canceled request is missing request.canceled")
+	}
+}
+
+// lateCancelContext reports context.Canceled once canceled is set, but its
+// Done channel never fires, so getActivityWithParams always sees the
+// finished fetch before it notices the cancellation.
+type lateCancelContext struct {
+	context.Context
+	canceled *atomic.Bool
+}
+
+func (ctx lateCancelContext) Done() <-chan struct{} { return nil }
+
+func (ctx lateCancelContext) Err() error {
+	if ctx.canceled.Load() {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestGetActivityReportsCancelAfterFetch(t *testing.T) {
+	_, recorder := NewTestProvider()
+	ctx := lateCancelContext{Context: context.Background(), canceled: new(atomic.Bool)}
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		ctx.canceled.Store(true)
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	if _, err := getActivityWithParams(ctx, "cooking"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if v, _ := spanAttr(span, "request.canceled"); !v.AsBool() {
+		t.Error("canceled request is missing request.canceled")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/histogram"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	"go.opentelemetry.io/otel/unit"
+)
+
+var meter = metric.Must(global.Meter("go-server"))
+
+var activeRequests = meter.NewInt64UpDownCounter(
+	"http.server.active_requests",
+	metric.WithDescription("Number of requests currently being handled"),
+)
+
+var serverErrors = meter.NewInt64Counter(
+	"http.server.errors",
+	metric.WithDescription("Number of requests that ended in a 5xx response"),
+)
+
+var cacheHits = meter.NewInt64Counter(
+	"activity.cache.hits",
+	metric.WithDescription("Number of activity lookups served from the cache"),
+)
+
+var cacheMisses = meter.NewInt64Counter(
+	"activity.cache.misses",
+	metric.WithDescription("Number of activity lookups that missed the cache"),
+)
+
+var upstreamResponses = meter.NewInt64Counter(
+	"activity.upstream.responses",
+	metric.WithDescription("Responses from the activity API by status class"),
+)
+
+var activityCoalesced = meter.NewInt64Counter(
+	"activity.coalesced",
+	metric.WithDescription("Number of activity lookups that piggybacked on an in-flight upstream fetch"),
+)
+
+// statusClass buckets an HTTP status code as 2xx, 4xx and so on.
+func statusClass(code int) string {
+	return fmt.Sprintf("%dxx", code/100)
+}
+
+var requestSize = meter.NewInt64ValueRecorder(
+	"http.server.request.size",
+	metric.WithDescription("Size of HTTP request bodies"),
+	metric.WithUnit(unit.Bytes),
+)
+
+const participantsMetric = "activity.participants"
+
+var activityParticipants = meter.NewInt64ValueRecorder(
+	participantsMetric,
+	metric.WithDescription("Number of participants in fetched activities"),
+)
+
+// participantsBuckets are histogram boundaries for small participant counts.
+var participantsBuckets = []float64{1, 2, 3, 4, 5, 8}
+
+// metricSelector aggregates activity.participants as a histogram, and
+// everything else with the cheaper default.
+type metricSelector struct {
+	fallback     export.AggregatorSelector
+	participants export.AggregatorSelector
+}
+
+func newMetricSelector() export.AggregatorSelector {
+	return metricSelector{
+		fallback:     simple.NewWithInexpensiveDistribution(),
+		participants: simple.NewWithHistogramDistribution(histogram.WithExplicitBoundaries(participantsBuckets)),
+	}
+}
+
+func (s metricSelector) AggregatorFor(descriptor *metric.Descriptor, aggs ...*export.Aggregator) {
+	if descriptor.Name() == participantsMetric {
+		s.participants.AggregatorFor(descriptor, aggs...)
+		return
+	}
+	s.fallback.AggregatorFor(descriptor, aggs...)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/global"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	metricprocessor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+)
+
+// testMeter collects the package's instruments for assertions. They're
+// created from the global meter at init and stay bound to the first provider
+// installed, so TestMain installs this one before any test runs.
+var testMeter = controller.New(
+	metricprocessor.New(newMetricSelector(), export.CumulativeExportKindSelector(), metricprocessor.WithMemory(true)),
+	controller.WithCollectPeriod(0),
+)
+
+func installTestMeter() {
+	global.SetMeterProvider(testMeter.MeterProvider())
+}
+
+// metricRecord returns the aggregation recorded for the instrument called name
+// whose labels include attr.
+func metricRecord(t *testing.T, name string, attr attribute.KeyValue) (aggregation.Aggregation, bool) {
+	t.Helper()
+	if err := testMeter.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var agg aggregation.Aggregation
+	err := testMeter.ForEach(export.CumulativeExportKindSelector(), func(r export.Record) error {
+		if r.Descriptor().Name() != name {
+			return nil
+		}
+		if v, ok := r.Labels().Value(attr.Key); ok && v == attr.Value {
+			agg = r.Aggregation()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agg, agg != nil
+}
+
+// metricSum returns the sum recorded for a counter, or 0 if there isn't one.
+func metricSum(t *testing.T, name string, attr attribute.KeyValue) int64 {
+	t.Helper()
+	agg, ok := metricRecord(t, name, attr)
+	if !ok {
+		return 0
+	}
+	sum, err := agg.(aggregation.Sum).Sum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum.AsInt64()
+}
+
+func TestFetchActivityCountsResponsesByStatusClass(t *testing.T) {
+	tests := []struct {
+		name  string
+		rt    roundTripperFunc
+		class string
+	}{
+		{"ok", func(req *http.Request) (*http.Response, error) {
+			return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+		}, "2xx"},
+		{"server error", func(req *http.Request) (*http.Response, error) {
+			return upstreamResponse(req, http.StatusInternalServerError, ""), nil
+		}, "5xx"},
+		{"transport error", func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection reset")
+		}, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class := attribute.String("status_class", tt.class)
+			before := metricSum(t, "activity.upstream.responses", class)
+			fetchActivity(context.Background(), &http.Client{Transport: tt.rt}, "http://upstream.test/api/activity", "")
+			if got := metricSum(t, "activity.upstream.responses", class) - before; got != 1 {
+				t.Errorf("%s responses went up by %d, want 1", tt.class, got)
+			}
+		})
+	}
+}
+
+func TestActivityParticipantsHistogram(t *testing.T) {
+	participants := []int{1, 2, 2, 6}
+	calls := 0
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body := fmt.Sprintf(`{"activity":"Nap","type":"histogram-test","participants":%d}`, participants[calls])
+		calls++
+		return upstreamResponse(req, http.StatusOK, body), nil
+	}))
+	for range participants {
+		if _, err := getActivityWithParams(context.Background(), "histogram-test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	agg, ok := metricRecord(t, "activity.participants", attribute.String("activity.type", "histogram-test"))
+	if !ok {
+		t.Fatal("no activity.participants record for the activity type")
+	}
+	buckets, err := agg.(aggregation.Histogram).Histogram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(buckets.Boundaries) != fmt.Sprint(participantsBuckets) {
+		t.Errorf("boundaries = %v, want %v", buckets.Boundaries, participantsBuckets)
+	}
+	// Buckets are [-inf,1) [1,2) [2,3) [3,4) [4,5) [5,8) [8,+inf).
+	if want := "[0 1 2 0 0 1 0]"; fmt.Sprint(buckets.Counts) != want {
+		t.Errorf("bucket counts = %v, want %s", buckets.Counts, want)
+	}
+}
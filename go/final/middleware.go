@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// QueueTimeMiddleware records how long a request waited between arriving at
+// the edge and being handled here, using the X-Request-Start header set by
+// load balancers and proxies.
+func QueueTimeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if arrival, ok := parseRequestStart(c.GetHeader("X-Request-Start")); ok {
+			queued := time.Since(arrival)
+			if queued < 0 {
+				queued = 0
+			}
+			oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(
+				attribute.Float64("server.queue_time_ms", float64(queued)/float64(time.Millisecond)),
+			)
+		}
+		c.Next()
+	}
+}
+
+// parseRequestStart parses an X-Request-Start value such as "t=1615394565.123"
+// or "1615394565123". Proxies disagree on the unit, so it's inferred from the
+// magnitude of the timestamp.
+func parseRequestStart(header string) (time.Time, bool) {
+	value, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(header), "t="), 64)
+	if err != nil || value <= 0 {
+		return time.Time{}, false
+	}
+	var nanos float64
+	switch {
+	case value > 1e15:
+		nanos = value * float64(time.Microsecond)
+	case value > 1e12:
+		nanos = value * float64(time.Millisecond)
+	default:
+		nanos = value * float64(time.Second)
+	}
+	sec, frac := math.Modf(nanos / float64(time.Second))
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), true
+}
+
+// ActiveRequestsMiddleware tracks the number of in-flight requests per route.
+func ActiveRequestsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		route := attribute.String("http.route", c.FullPath())
+		activeRequests.Add(ctx, 1, route)
+		defer activeRequests.Add(ctx, -1, route)
+		c.Next()
+	}
+}
+
+// RuntimeMiddleware records the number of running goroutines when each
+// request is handled.
+func RuntimeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(
+			attribute.Int("runtime.num_goroutine", runtime.NumGoroutine()),
+		)
+		c.Next()
+	}
+}
+
+// DeadlineMiddleware applies the deadline in an X-Request-Deadline header
+// (RFC3339) to the request context, rejecting requests whose deadline has
+// already passed.
+func DeadlineMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deadline, err := time.Parse(time.RFC3339, c.GetHeader("X-Request-Deadline"))
+		if err != nil {
+			c.Next()
+			return
+		}
+		span := oteltrace.SpanFromContext(c.Request.Context())
+		span.SetAttributes(attribute.String("request.deadline", deadline.Format(time.RFC3339Nano)))
+		if !time.Now().Before(deadline) {
+			span.SetAttributes(attribute.Bool("deadline.exceeded", true))
+			c.AbortWithStatus(http.StatusGatewayTimeout)
+			return
+		}
+		ctx, cancel := context.WithDeadline(c.Request.Context(), deadline)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// JSONBodyMiddleware rejects request bodies larger than maxBytes or that
+// aren't valid JSON, recording the reason on the span.
+func JSONBodyMiddleware(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		span := oteltrace.SpanFromContext(c.Request.Context())
+		body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				span.SetAttributes(attribute.Bool("request.too_large", true))
+				c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+				return
+			}
+			span.AddEvent(err.Error())
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if !json.Valid(body) {
+			span.SetAttributes(attribute.Bool("request.invalid_json", true))
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+// RequestAttributesMiddleware sets the method, scheme and server address on
+// the span, since not every otelgin version records them.
+func RequestAttributesMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		host := c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(
+			attribute.String("http.method", c.Request.Method),
+			attribute.String("http.scheme", scheme),
+			attribute.String("server.address", host),
+		)
+		c.Next()
+	}
+}
+
+// SlowRequestMiddleware adds a handler.slow event to the span and logs a
+// warning if a request is still being handled after threshold.
+func SlowRequestMiddleware(threshold time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		span := oteltrace.SpanFromContext(c.Request.Context())
+		method, route := c.Request.Method, c.FullPath()
+		timer := time.AfterFunc(threshold, func() {
+			span.AddEvent("handler.slow", oteltrace.WithAttributes(
+				attribute.Int64("threshold_ms", threshold.Milliseconds()),
+			))
+			log.Printf("Slow request: %s %s still running after %s (trace_id=%s)",
+				method, route, threshold, span.SpanContext().TraceID)
+		})
+		defer timer.Stop()
+		c.Next()
+	}
+}
+
+// SyntheticMiddleware marks spans for requests from health checkers and load
+// generators, identified by the given header or a known user agent substring.
+func SyntheticMiddleware(header string, userAgents []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		synthetic := c.GetHeader(header) == "1"
+		ua := c.Request.UserAgent()
+		for _, agent := range userAgents {
+			if agent = strings.TrimSpace(agent); agent != "" && strings.Contains(ua, agent) {
+				synthetic = true
+			}
+		}
+		if synthetic {
+			oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Bool("synthetic", true))
+		}
+		c.Next()
+	}
+}
+
+// HandlerNameMiddleware records the Go function handling the request as
+// code.function and code.namespace.
+func HandlerNameMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.HandlerName()
+		attrs := []attribute.KeyValue{attribute.String("code.function", name)}
+		if i := strings.LastIndex(name, "."); i > 0 {
+			attrs = []attribute.KeyValue{
+				attribute.String("code.function", name[i+1:]),
+				attribute.String("code.namespace", name[:i]),
+			}
+		}
+		oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(attrs...)
+		c.Next()
+	}
+}
+
+// BaggageLimitMiddleware drops incoming baggage members once their encoded
+// size exceeds maxBytes, so we don't forward ever-growing baggage upstream.
+func BaggageLimitMiddleware(maxBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var kept []attribute.KeyValue
+		size, truncated := 0, false
+		members := baggage.Set(ctx)
+		for _, kv := range members.ToSlice() {
+			memberSize := len(kv.Key) + 1 + len(kv.Value.Emit())
+			if len(kept) > 0 {
+				memberSize++ // separating comma
+			}
+			if size+memberSize > maxBytes {
+				truncated = true
+				continue
+			}
+			size += memberSize
+			kept = append(kept, kv)
+		}
+		if truncated {
+			ctx = baggage.ContextWithValues(baggage.ContextWithEmpty(ctx), kept...)
+			c.Request = c.Request.WithContext(ctx)
+			oteltrace.SpanFromContext(ctx).SetAttributes(attribute.Bool("baggage.truncated", true))
+		}
+		c.Next()
+	}
+}
+
+// RequestSizeMiddleware records the size of each request body. Chunked
+// requests don't declare a length, so for those it counts the bytes the
+// handler actually read.
+func RequestSizeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := &countingReadCloser{ReadCloser: c.Request.Body}
+		c.Request.Body = body
+		c.Next()
+		size := c.Request.ContentLength
+		if size < 0 {
+			size = body.n
+		}
+		requestSize.Record(c.Request.Context(), size, attribute.String("http.route", c.FullPath()))
+	}
+}
+
+// AcceptLanguageMiddleware records the client's preferred language, the first
+// tag in its Accept-Language header.
+func AcceptLanguageMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lang := strings.SplitN(c.GetHeader("Accept-Language"), ",", 2)[0]
+		lang = strings.TrimSpace(strings.SplitN(lang, ";", 2)[0])
+		if lang != "" {
+			oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(
+				attribute.String("http.request.header.accept_language", lang),
+			)
+		}
+		c.Next()
+	}
+}
+
+// ProtocolVersionMiddleware records the request's HTTP version, e.g. "1.1"
+// or "2".
+func ProtocolVersionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(
+			attribute.String("network.protocol.version", protocolVersion(c.Request.Proto)),
+		)
+		c.Next()
+	}
+}
+
+// RouteGroupMiddleware records the leading segment of the matched route, such
+// as /demo for /demo/slow, as http.route.group. Routes outside a group aren't
+// given one.
+func RouteGroupMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if group, ok := routeGroup(c.FullPath()); ok {
+			oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(
+				attribute.String("http.route.group", group),
+			)
+		}
+		c.Next()
+	}
+}
+
+func routeGroup(route string) (string, bool) {
+	segments := strings.SplitN(strings.TrimPrefix(route, "/"), "/", 2)
+	if len(segments) < 2 {
+		return "", false
+	}
+	return "/" + segments[0], true
+}
+
+// protocolVersion strips the scheme from a request's Proto, dropping the
+// ".0" minor version from HTTP/2 and later.
+func protocolVersion(proto string) string {
+	version := strings.TrimPrefix(proto, "HTTP/")
+	if version != "1.0" {
+		version = strings.TrimSuffix(version, ".0")
+	}
+	return version
+}
+
+// QueryParamsMiddleware records the allowlisted query parameters as
+// http.request.query.<key> attributes. Anything else is ignored, so
+// unexpected parameters never end up in traces.
+func QueryParamsMiddleware(allowlist []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		var attrs []attribute.KeyValue
+		for _, key := range allowlist {
+			if values, ok := query[key]; ok {
+				attrs = append(attrs, attribute.Array("http.request.query."+key, values))
+			}
+		}
+		if len(attrs) > 0 {
+			oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(attrs...)
+		}
+		c.Next()
+	}
+}
+
+// accessLogEntry is a JSON access log line. The trace_id, span_id and
+// trace_flags fields follow OpenTelemetry's log correlation convention, so
+// collectors can link the line to its trace.
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Route      string  `json:"route,omitempty"`
+	Status     int     `json:"status"`
+	DurationMS float64 `json:"duration_ms"`
+	ClientIP   string  `json:"client_ip"`
+	TraceID    string  `json:"trace_id,omitempty"`
+	SpanID     string  `json:"span_id,omitempty"`
+	TraceFlags string  `json:"trace_flags,omitempty"`
+}
+
+// accessLogOutput is where AccessLogMiddleware writes, stdout for collectors
+// to scrape.
+var accessLogOutput io.Writer = os.Stdout
+
+// AccessLogMiddleware writes a JSON access log line to stdout for each
+// request, including the IDs of its span.
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		entry := accessLogEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			Route:      c.FullPath(),
+			Status:     c.Writer.Status(),
+			DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+			ClientIP:   c.ClientIP(),
+		}
+		if sc := oteltrace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			entry.TraceID = sc.TraceID.String()
+			entry.SpanID = sc.SpanID.String()
+			entry.TraceFlags = fmt.Sprintf("%02x", sc.TraceFlags)
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		accessLogOutput.Write(append(line, '\n'))
+	}
+}
+
+// GzipMiddleware compresses responses for clients that accept gzip,
+// recording that it did and the compressed-to-original size ratio.
+func GzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			c.Next()
+			return
+		}
+		w := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if w.buf.Len() == 0 {
+			return
+		}
+
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(w.buf.Bytes())
+		gz.Close()
+		oteltrace.SpanFromContext(c.Request.Context()).SetAttributes(
+			attribute.Bool("http.response.compressed", true),
+			attribute.Float64("http.response.compression_ratio", float64(compressed.Len())/float64(w.buf.Len())),
+		)
+		c.Header("Content-Encoding", "gzip")
+		c.Header("Vary", "Accept-Encoding")
+		c.Writer.Header().Del("Content-Length")
+		c.Writer.Write(compressed.Bytes())
+	}
+}
+
+// bufferedWriter holds the response body so it can be compressed once the
+// handler has finished.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.buf.WriteString(s)
+}
+
+// GinErrorsMiddleware counts 5xx responses by route, and marks the span as
+// failed if the handler attached errors to the context with c.Error,
+// recording each one as an error event.
+func GinErrorsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		ctx := c.Request.Context()
+		if status := c.Writer.Status(); status >= http.StatusInternalServerError {
+			serverErrors.Add(ctx, 1,
+				attribute.String("http.route", c.FullPath()),
+				attribute.Int("http.status_code", status),
+			)
+		}
+		if len(c.Errors) == 0 {
+			return
+		}
+		span := oteltrace.SpanFromContext(ctx)
+		for _, err := range c.Errors {
+			span.RecordError(err.Err)
+		}
+		span.SetStatus(codes.Error, c.Errors.Last().Error())
+	}
+}
+
+// stickyErrorTracerProvider hands out spans whose error status can't be
+// cleared. otelgin sets the status from the response code once the handlers
+// have returned, which would otherwise overwrite the error
+// GinErrorsMiddleware recorded for a request that still answered 2xx.
+type stickyErrorTracerProvider struct {
+	oteltrace.TracerProvider
+}
+
+func (tp stickyErrorTracerProvider) Tracer(name string, opts ...oteltrace.TracerOption) oteltrace.Tracer {
+	return stickyErrorTracer{tp.TracerProvider.Tracer(name, opts...)}
+}
+
+type stickyErrorTracer struct {
+	oteltrace.Tracer
+}
+
+func (t stickyErrorTracer) Start(ctx context.Context, name string, opts ...oteltrace.SpanOption) (context.Context, oteltrace.Span) {
+	ctx, span := t.Tracer.Start(ctx, name, opts...)
+	sticky := &stickyErrorSpan{Span: span}
+	return oteltrace.ContextWithSpan(ctx, sticky), sticky
+}
+
+type stickyErrorSpan struct {
+	oteltrace.Span
+	mu     sync.Mutex
+	failed bool
+}
+
+func (s *stickyErrorSpan) SetStatus(code codes.Code, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed && code != codes.Error {
+		return
+	}
+	s.failed = code == codes.Error
+	s.Span.SetStatus(code, msg)
+}
+
+func (s *stickyErrorSpan) RecordError(err error, opts ...oteltrace.EventOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The SDK marks the span as failed when it records an error.
+	s.failed = s.failed || err != nil
+	s.Span.RecordError(err, opts...)
+}
+
+// AttributeBudgetMiddleware counts the distinct attribute keys set on the
+// request span through the request context. Once more than budget have been
+// set, it logs a warning and flags the span, to catch accidental
+// high-cardinality instrumentation.
+func AttributeBudgetMiddleware(budget int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		span := &budgetSpan{
+			Span:   oteltrace.SpanFromContext(ctx),
+			route:  c.FullPath(),
+			budget: budget,
+			keys:   map[attribute.Key]struct{}{},
+		}
+		c.Request = c.Request.WithContext(oteltrace.ContextWithSpan(ctx, span))
+		c.Next()
+	}
+}
+
+type budgetSpan struct {
+	oteltrace.Span
+	route    string
+	budget   int
+	mu       sync.Mutex
+	keys     map[attribute.Key]struct{}
+	exceeded bool
+}
+
+func (s *budgetSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.Span.SetAttributes(kv...)
+	s.mu.Lock()
+	for _, a := range kv {
+		s.keys[a.Key] = struct{}{}
+	}
+	exceeded := !s.exceeded && len(s.keys) > s.budget
+	if exceeded {
+		s.exceeded = true
+	}
+	s.mu.Unlock()
+	if exceeded {
+		log.Printf("Request span for %s exceeded its budget of %d attributes", s.route, s.budget)
+		s.Span.SetAttributes(attribute.Bool("otel.attributes.budget_exceeded", true))
+	}
+}
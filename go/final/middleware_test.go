@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// serveTraced serves req through otelgin, then handlers, the last of which
+// handles req's path. It returns the response and the server span.
+func serveTraced(t *testing.T, req *http.Request, handlers ...gin.HandlerFunc) (*httptest.ResponseRecorder, sdktrace.ReadOnlySpan) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	provider, recorder := NewTestProvider()
+	router := gin.New()
+	router.Use(otelgin.Middleware("go-server", otelgin.WithTracerProvider(stickyErrorTracerProvider{provider})))
+	router.Use(handlers[:len(handlers)-1]...)
+	router.Handle(req.Method, req.URL.Path, handlers[len(handlers)-1])
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w, endedSpan(t, recorder, req.URL.Path)
+}
+
+func respondOK(c *gin.Context) { c.String(http.StatusOK, "ok") }
+
+func TestQueueTimeMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	arrival := time.Now().Add(-250 * time.Millisecond)
+	req.Header.Set("X-Request-Start", "t="+strconv.FormatInt(arrival.UnixNano()/int64(time.Millisecond), 10))
+	_, span := serveTraced(t, req, QueueTimeMiddleware(), respondOK)
+	v, found := spanAttr(span, "server.queue_time_ms")
+	if !found {
+		t.Fatal("server.queue_time_ms not recorded")
+	}
+	if ms := v.AsFloat64(); ms < 250 || ms > 10000 {
+		t.Errorf("server.queue_time_ms = %v, want about 250", ms)
+	}
+}
+
+func TestActiveRequestsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	route := attribute.String("http.route", "/held")
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	router := gin.New()
+	router.Use(ActiveRequestsMiddleware())
+	router.GET("/held", func(c *gin.Context) {
+		close(entered)
+		<-release
+		panic("handler failed")
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() { recover() }()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/held", nil))
+	}()
+
+	<-entered
+	if got := metricSum(t, "http.server.active_requests", route); got != 1 {
+		t.Errorf("active requests while held = %d, want 1", got)
+	}
+	close(release)
+	<-done
+	// The count is decremented even though the handler panicked.
+	if got := metricSum(t, "http.server.active_requests", route); got != 0 {
+		t.Errorf("active requests after the panic = %d, want 0", got)
+	}
+}
+
+func TestRuntimeAttributeNeedsDebugRuntime(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, enabled := range []bool{false, true} {
+		if enabled {
+			t.Setenv("DEBUG_RUNTIME", "true")
+		}
+		_, recorder := NewTestProvider()
+		newRouter(nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		_, found := spanAttr(endedSpan(t, recorder, "/"), "runtime.num_goroutine")
+		if found != enabled {
+			t.Errorf("DEBUG_RUNTIME=%v: runtime.num_goroutine recorded = %v", enabled, found)
+		}
+	}
+}
+
+// The router has no request dump middleware, so request bodies and headers
+// are never written to the log or gin's output.
+func TestRouterDoesNotDumpRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	defer func(w, errW io.Writer) { gin.DefaultWriter, gin.DefaultErrorWriter = w, errW }(gin.DefaultWriter, gin.DefaultErrorWriter)
+	gin.DefaultWriter, gin.DefaultErrorWriter = &out, &out
+
+	router := newRouter(nil)
+	for _, h := range router.Handlers {
+		if name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name(); strings.Contains(strings.ToLower(name), "dump") {
+			t.Errorf("router runs %s", name)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", strings.NewReader("secret=hunter2"))
+	req.Header.Set("Authorization", "Bearer hunter2")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if strings.Contains(out.String(), "hunter2") {
+		t.Errorf("request was dumped to the log:\n%s", out.String())
+	}
+}
+
+func TestDeadlineMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		deadline   time.Time
+		wantStatus int
+		exceeded   bool
+	}{
+		{"past", time.Now().Add(-time.Minute), http.StatusGatewayTimeout, true},
+		{"future", time.Now().Add(time.Minute), http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Request-Deadline", tt.deadline.Format(time.RFC3339))
+			var handlerDeadline time.Time
+			w, span := serveTraced(t, req, DeadlineMiddleware(), func(c *gin.Context) {
+				handlerDeadline, _ = c.Request.Context().Deadline()
+				respondOK(c)
+			})
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if _, found := spanAttr(span, "request.deadline"); !found {
+				t.Error("request.deadline not recorded")
+			}
+			v, _ := spanAttr(span, "deadline.exceeded")
+			if v.AsBool() != tt.exceeded {
+				t.Errorf("deadline.exceeded = %v, want %v", v.AsBool(), tt.exceeded)
+			}
+			if !tt.exceeded && !handlerDeadline.Equal(tt.deadline.Truncate(time.Second)) {
+				t.Errorf("handler deadline = %v, want %v", handlerDeadline, tt.deadline)
+			}
+		})
+	}
+}
+
+func TestJSONBodyMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantAttr   attribute.Key
+	}{
+		{"valid", `{"types":["diy"]}`, http.StatusOK, ""},
+		{"too large", `{"types":["` + strings.Repeat("a", 64) + `"]}`, http.StatusRequestEntityTooLarge, "request.too_large"},
+		{"malformed", `{"types":`, http.StatusBadRequest, "request.invalid_json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/getActivities", strings.NewReader(tt.body))
+			var handled string
+			w, span := serveTraced(t, req, JSONBodyMiddleware(32), func(c *gin.Context) {
+				body, _ := ioutil.ReadAll(c.Request.Body)
+				handled = string(body)
+				respondOK(c)
+			})
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantAttr == "" {
+				if handled != tt.body {
+					t.Errorf("handler read %q, want the original body", handled)
+				}
+				return
+			}
+			if v, _ := spanAttr(span, tt.wantAttr); !v.AsBool() {
+				t.Errorf("%s not recorded", tt.wantAttr)
+			}
+		})
+	}
+}
+
+func TestRequestAttributesMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://cats.example:8080/", nil)
+	_, span := serveTraced(t, req, RequestAttributesMiddleware(), respondOK)
+	want := map[attribute.Key]string{
+		"http.method":    "GET",
+		"http.scheme":    "http",
+		"server.address": "cats.example",
+	}
+	for key, value := range want {
+		if got, _ := spanAttr(span, key); got.AsString() != value {
+			t.Errorf("%s = %q, want %q", key, got.AsString(), value)
+		}
+	}
+}
+
+// chanWriter sends each write to a channel, so a test can wait for a log
+// line written from another goroutine.
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestSlowRequestMiddleware(t *testing.T) {
+	logs := make(chanWriter, 10)
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	_, span := serveTraced(t, httptest.NewRequest(http.MethodGet, "/slow", nil), SlowRequestMiddleware(10*time.Millisecond), func(c *gin.Context) {
+		time.Sleep(100 * time.Millisecond)
+		respondOK(c)
+	})
+	found := false
+	for _, event := range span.Events() {
+		found = found || event.Name == "handler.slow"
+	}
+	if !found {
+		t.Error("no handler.slow event recorded")
+	}
+	want := "trace_id=" + span.SpanContext().TraceID.String()
+	select {
+	case line := <-logs:
+		if !strings.Contains(line, want) {
+			t.Errorf("log %q doesn't mention %s", line, want)
+		}
+	case <-time.After(time.Second):
+		t.Error("no slow request warning logged")
+	}
+}
+
+func TestSyntheticMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		userAgent string
+		want      bool
+	}{
+		{"header", "1", "Mozilla/5.0", true},
+		{"user agent", "", "kube-probe/1.27", true},
+		{"browser", "", "Mozilla/5.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			if tt.header != "" {
+				req.Header.Set("X-Synthetic", tt.header)
+			}
+			_, span := serveTraced(t, req, SyntheticMiddleware("X-Synthetic", []string{"kube-probe"}), respondOK)
+			if _, found := spanAttr(span, "synthetic"); found != tt.want {
+				t.Errorf("synthetic recorded = %v, want %v", found, tt.want)
+			}
+		})
+	}
+}
+
+func TestBaggageLimitMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("baggage", "cat=garfield,note="+strings.Repeat("m", 50))
+	var kept []attribute.KeyValue
+	_, span := serveTraced(t, req, BaggageLimitMiddleware(20), func(c *gin.Context) {
+		members := baggage.Set(c.Request.Context())
+		kept = members.ToSlice()
+		respondOK(c)
+	})
+	if len(kept) != 1 || kept[0].Key != "cat" {
+		t.Errorf("baggage after truncation = %v, want only cat", kept)
+	}
+	if v, _ := spanAttr(span, "baggage.truncated"); !v.AsBool() {
+		t.Error("baggage.truncated not recorded")
+	}
+}
+
+func TestRequestSizeMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := strings.Repeat("c", 123)
+	router := gin.New()
+	router.Use(RequestSizeMiddleware())
+	handler := func(c *gin.Context) {
+		ioutil.ReadAll(c.Request.Body)
+		respondOK(c)
+	}
+	router.POST("/sized", handler)
+	router.POST("/chunked", handler)
+
+	for _, path := range []string{"/sized", "/chunked"} {
+		route := attribute.String("http.route", path)
+		count, sum := requestSizeStats(t, route)
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if path == "/chunked" {
+			req.ContentLength = -1
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		newCount, newSum := requestSizeStats(t, route)
+		if newCount-count != 1 || newSum-sum != int64(len(body)) {
+			t.Errorf("%s: recorded %d values totalling %d bytes, want 1 of %d", path, newCount-count, newSum-sum, len(body))
+		}
+	}
+}
+
+// requestSizeStats returns the number and total of request sizes recorded
+// with attr.
+func requestSizeStats(t *testing.T, attr attribute.KeyValue) (uint64, int64) {
+	t.Helper()
+	agg, ok := metricRecord(t, "http.server.request.size", attr)
+	if !ok {
+		return 0, 0
+	}
+	count, err := agg.(aggregation.Count).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := agg.(aggregation.Sum).Sum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count, sum.AsInt64()
+}
+
+func TestAcceptLanguageMiddleware(t *testing.T) {
+	tests := map[string]string{
+		"fr-CA,fr;q=0.9,en;q=0.8": "fr-CA",
+		"de;q=0.7":                "de",
+		"":                        "",
+	}
+	for header, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Accept-Language", header)
+		}
+		_, span := serveTraced(t, req, AcceptLanguageMiddleware(), respondOK)
+		v, found := spanAttr(span, "http.request.header.accept_language")
+		if v.AsString() != want || found != (want != "") {
+			t.Errorf("Accept-Language %q: attribute = %q (recorded %v), want %q", header, v.AsString(), found, want)
+		}
+	}
+}
+
+func TestAttributeBudgetMiddleware(t *testing.T) {
+	setAttrs := func(n int) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			span := oteltrace.SpanFromContext(c.Request.Context())
+			for i := 0; i < n; i++ {
+				span.SetAttributes(attribute.Int("test.attr."+strconv.Itoa(i), i))
+			}
+			respondOK(c)
+		}
+	}
+
+	_, span := serveTraced(t, httptest.NewRequest(http.MethodGet, "/", nil), AttributeBudgetMiddleware(5), setAttrs(8))
+	if v, _ := spanAttr(span, "otel.attributes.budget_exceeded"); !v.AsBool() {
+		t.Error("otel.attributes.budget_exceeded not set on a span over budget")
+	}
+
+	_, span = serveTraced(t, httptest.NewRequest(http.MethodGet, "/", nil), AttributeBudgetMiddleware(5), setAttrs(5))
+	if _, found := spanAttr(span, "otel.attributes.budget_exceeded"); found {
+		t.Error("otel.attributes.budget_exceeded set on a span within budget")
+	}
+}
+
+func TestProtocolVersionMiddleware(t *testing.T) {
+	tests := []struct {
+		proto string
+		major int
+		minor int
+		want  string
+	}{
+		{"HTTP/1.0", 1, 0, "1.0"},
+		{"HTTP/1.1", 1, 1, "1.1"},
+		{"HTTP/2.0", 2, 0, "2"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Proto, req.ProtoMajor, req.ProtoMinor = tt.proto, tt.major, tt.minor
+		_, span := serveTraced(t, req, ProtocolVersionMiddleware(), respondOK)
+		if v, _ := spanAttr(span, "network.protocol.version"); v.AsString() != tt.want {
+			t.Errorf("%s: network.protocol.version = %q, want %q", tt.proto, v.AsString(), tt.want)
+		}
+	}
+}
+
+func TestGinErrorsMiddleware(t *testing.T) {
+	_, span := serveTraced(t, httptest.NewRequest(http.MethodGet, "/", nil), GinErrorsMiddleware(), func(c *gin.Context) {
+		c.Error(errors.New("hairball"))
+		c.Error(errors.New("no tuna"))
+		respondOK(c)
+	})
+	if span.StatusCode() != codes.Error || span.StatusMessage() != "no tuna" {
+		t.Errorf("status = %v %q, want Error %q", span.StatusCode(), span.StatusMessage(), "no tuna")
+	}
+	var messages []string
+	for _, event := range span.Events() {
+		if event.Name != "error" {
+			continue
+		}
+		for _, a := range event.Attributes {
+			if a.Key == "error.message" {
+				messages = append(messages, a.Value.AsString())
+			}
+		}
+	}
+	if strings.Join(messages, ",") != "hairball,no tuna" {
+		t.Errorf("exception events = %q, want an error event per gin error", messages)
+	}
+
+	_, span = serveTraced(t, httptest.NewRequest(http.MethodGet, "/", nil), GinErrorsMiddleware(), respondOK)
+	if span.StatusCode() == codes.Error {
+		t.Error("status set to Error without any gin errors")
+	}
+}
+
+func TestQueryParamsMiddleware(t *testing.T) {
+	t.Setenv("QUERY_PARAM_ALLOWLIST", "type, participants,")
+	allowlist := queryParamAllowlist()
+	req := httptest.NewRequest(http.MethodGet, "/?type=diy&type=social&participants=2&token=s3cret", nil)
+	_, span := serveTraced(t, req, QueryParamsMiddleware(allowlist), respondOK)
+
+	if v, _ := spanAttr(span, "http.request.query.type"); fmt.Sprint(v.AsArray()) != "[diy social]" {
+		t.Errorf("http.request.query.type = %v, want [diy social]", v.AsArray())
+	}
+	if v, _ := spanAttr(span, "http.request.query.participants"); fmt.Sprint(v.AsArray()) != "[2]" {
+		t.Errorf("http.request.query.participants = %v, want [2]", v.AsArray())
+	}
+	for _, a := range span.Attributes() {
+		if strings.HasPrefix(string(a.Key), "http.request.query.") && a.Key != "http.request.query.type" && a.Key != "http.request.query.participants" {
+			t.Errorf("recorded %s, which isn't allowlisted", a.Key)
+		}
+	}
+}
+
+func TestGinErrorsMiddlewareCountsServerErrors(t *testing.T) {
+	// The metric is cumulative across tests, so use a route no other test serves.
+	const path = "/server-errors-counted"
+	route := attribute.String("http.route", path)
+	before := metricSum(t, "http.server.errors", route)
+	for _, status := range []int{http.StatusInternalServerError, http.StatusOK, http.StatusNotFound} {
+		serveTraced(t, httptest.NewRequest(http.MethodGet, path, nil), GinErrorsMiddleware(), func(c *gin.Context) {
+			c.Status(status)
+		})
+	}
+	if got := metricSum(t, "http.server.errors", route) - before; got != 1 {
+		t.Errorf("http.server.errors for %s grew by %d, want 1", route.Value.AsString(), got)
+	}
+	err := testMeter.ForEach(export.CumulativeExportKindSelector(), func(r export.Record) error {
+		if r.Descriptor().Name() != "http.server.errors" {
+			return nil
+		}
+		if v, _ := r.Labels().Value(route.Key); v != route.Value {
+			return nil
+		}
+		if status, _ := r.Labels().Value("http.status_code"); status.AsInt64() != http.StatusInternalServerError {
+			t.Errorf("counted http.status_code %d, want only 500", status.AsInt64())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	body := strings.Repeat("purr ", 200)
+	respond := func(c *gin.Context) { c.String(http.StatusOK, body) }
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w, span := serveTraced(t, req, GzipMiddleware(), respond)
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decompressed) != body {
+		t.Errorf("decompressed body = %.40q..., want the handler's body", decompressed)
+	}
+	if v, _ := spanAttr(span, "http.response.compressed"); !v.AsBool() {
+		t.Error("http.response.compressed not set")
+	}
+	if v, _ := spanAttr(span, "http.response.compression_ratio"); v.AsFloat64() <= 0 || v.AsFloat64() >= 1 {
+		t.Errorf("http.response.compression_ratio = %v, want between 0 and 1", v.AsFloat64())
+	}
+
+	w, span = serveTraced(t, httptest.NewRequest(http.MethodGet, "/", nil), GzipMiddleware(), respond)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Error("compressed the response for a client that doesn't accept gzip")
+	}
+	if _, found := spanAttr(span, "http.response.compressed"); found {
+		t.Error("http.response.compressed set on an uncompressed response")
+	}
+}
+
+func TestRouteGroupMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, recorder := NewTestProvider()
+	router := newRouter(nil)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/demo/error?code=418", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if v, _ := spanAttr(endedSpan(t, recorder, "/demo/error"), "http.route.group"); v.AsString() != "/demo" {
+		t.Errorf("/demo/error: http.route.group = %q, want /demo", v.AsString())
+	}
+	if v, found := spanAttr(endedSpan(t, recorder, "/"), "http.route.group"); found {
+		t.Errorf("/: http.route.group = %q, want none", v.AsString())
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	defer func(old io.Writer) { accessLogOutput = old }(accessLogOutput)
+	accessLogOutput = &out
+
+	_, span := serveTraced(t, httptest.NewRequest(http.MethodGet, "/nap", nil), AccessLogMiddleware(), respondOK)
+	var entry accessLogEntry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("access log line %q: %v", out.String(), err)
+	}
+	sc := span.SpanContext()
+	if entry.TraceID != sc.TraceID.String() || entry.SpanID != sc.SpanID.String() {
+		t.Errorf("logged trace %s span %s, want %s %s", entry.TraceID, entry.SpanID, sc.TraceID, sc.SpanID)
+	}
+	if entry.TraceFlags != "01" {
+		t.Errorf("trace_flags = %q, want 01", entry.TraceFlags)
+	}
+	if entry.Route != "/nap" || entry.Status != http.StatusOK {
+		t.Errorf("route %q status %d, want /nap 200", entry.Route, entry.Status)
+	}
+}
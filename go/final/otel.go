@@ -2,49 +2,301 @@ package main
 
 import (
 	"context"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
 	"os"
+	"runtime/debug"
 	"time"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
-	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/metric/global"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	metricprocessor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/semconv"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"final/telemetry"
 )
 
-// InitOpenTelemetetry initializes OpenTelemetry
-func InitOpenTelemetry(ctx context.Context) {
-	endpoint := "localhost:4317"
-	if collector, ok := os.LookupEnv("COLLECTOR_ENDPOINT"); ok {
-		endpoint = collector
+// serviceInstanceID tells replicas apart. It's read from
+// OTEL_SERVICE_INSTANCE_ID or generated once at startup.
+var serviceInstanceID = loadServiceInstanceID()
+
+func loadServiceInstanceID() string {
+	if id, ok := os.LookupEnv("OTEL_SERVICE_INSTANCE_ID"); ok {
+		return id
 	}
-	driver := otlpgrpc.NewDriver(
-		otlpgrpc.WithEndpoint(endpoint),
-		otlpgrpc.WithInsecure(),
-	)
-	exporter, err := otlp.NewExporter(ctx, driver)
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		log.Printf("Failed to generate service instance ID: %v", err)
+		return "unknown"
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// readBuildInfo is debug.ReadBuildInfo, replaced in tests, whose binaries
+// carry no VCS information.
+var readBuildInfo = debug.ReadBuildInfo
+
+// vcsRevision returns the commit the binary was built from, which go build
+// records when building inside a git checkout.
+func vcsRevision() (string, bool) {
+	info, ok := readBuildInfo()
+	if !ok {
+		return "", false
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && setting.Value != "" {
+			return setting.Value, true
+		}
+	}
+	return "", false
+}
+
+var (
+	// meterShutdown stops the metric controller. The global meter provider
+	// can only be set once, so the controller lives for the whole process.
+	meterShutdown func(context.Context) error
+	// tracerShutdown stops the current tracer provider.
+	tracerShutdown func(context.Context) error
+)
+
+// InitOpenTelemetetry initializes OpenTelemetry. Calling it again builds a
+// new tracer provider, installs it, and only then shuts down the previous
+// one, so spans keep flowing during the switch. Metrics are set up on the
+// first call only.
+func InitOpenTelemetry(ctx context.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	sampler, err := cfg.sampler()
 	if err != nil {
-		log.Fatalf("Failed to create collector exporter: %v", err)
+		return fmt.Errorf("failed to create sampler: %w", err)
 	}
 
+	attrs := []attribute.KeyValue{
+		semconv.ServiceNameKey.String(cfg.ServiceName),
+		semconv.ServiceInstanceIDKey.String(serviceInstanceID),
+	}
+	if region, ok := os.LookupEnv("DEPLOY_REGION"); ok && region != "" {
+		attrs = append(attrs, semconv.CloudRegionKey.String(region))
+	}
+	if zone, ok := os.LookupEnv("DEPLOY_ZONE"); ok && zone != "" {
+		attrs = append(attrs, attribute.String("cloud.availability_zone", zone))
+	}
+	if revision, ok := vcsRevision(); ok {
+		attrs = append(attrs,
+			semconv.ServiceVersionKey.String(revision),
+			attribute.String("vcs.revision", revision),
+		)
+	}
+	for k, v := range cfg.ResourceAttributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
 	res, err := resource.New(ctx,
-		resource.WithAttributes(semconv.ServiceNameKey.String("go-server")),
+		resource.WithAttributes(attrs...),
 	)
+	if err != nil {
+		return fmt.Errorf("failed to create resources: %w", err)
+	}
+
+	var exporter exporttrace.SpanExporter
+	switch cfg.Exporter.Name {
+	case "otlp":
+		driver, err := telemetry.NewOTLPDriver(cfg.Exporter.Protocol, cfg.Exporter.Endpoint)
+		if err != nil {
+			return err
+		}
+		otlpExporter, err := otlp.NewExporter(ctx, driver)
+		if err != nil {
+			return fmt.Errorf("failed to create collector exporter: %w", err)
+		}
+		exporter = timeoutExporter{otlpExporter, cfg.Exporter.Timeout}
+
+		if meterShutdown == nil {
+			if err := startMetrics(ctx, cfg, res); err != nil {
+				return err
+			}
+		}
+	case "file":
+		exporter, err = newFileExporter(cfg.Exporter.Path)
+		if err != nil {
+			return fmt.Errorf("failed to create file exporter: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown traces exporter %q", cfg.Exporter.Name)
+	}
+
+	processor, err := newSpanProcessor(cfg.SpanProcessor, exporter)
+	if err != nil {
+		return err
+	}
+	if cfg.ValidateSemconv {
+		processor = semconvValidator{processor}
+	}
+	if cfg.DropOrphans {
+		processor = newOrphanFilter(processor)
+	}
+	if cfg.KeepErrors {
+		processor = errorKeepingProcessor{processor}
+	}
+	if !cfg.Trace404 {
+		processor = notFoundFilter{processor}
+	}
 
 	provider := sdktrace.NewTracerProvider(
-		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sampler}),
 		sdktrace.WithResource(res),
-		sdktrace.WithBatcher(
-			exporter,
-			sdktrace.WithBatchTimeout(5*time.Second),
-			sdktrace.WithMaxExportBatchSize(10),
-		),
+		sdktrace.WithSpanProcessor(sessionProcessor{}),
+		sdktrace.WithSpanProcessor(processor),
 	)
 
-	otel.SetTracerProvider(provider)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(logErrorHandler{})
+	var tracerProvider oteltrace.TracerProvider = provider
+	if cfg.KeepErrors {
+		tracerProvider = recordingTracerProvider{provider}
+	}
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(cfg.propagator())
+
+	previous := tracerShutdown
+	tracerShutdown = provider.Shutdown
+	if previous != nil {
+		if err := previous(ctx); err != nil {
+			log.Printf("Failed to shut down previous tracer provider: %v", err)
+		}
+	}
 	log.Println("opentelemetry configured!")
+	if cfg.SelfTest {
+		selfTest(ctx, exporter, res)
+	}
+	return nil
+}
+
+// startMetrics starts pushing metrics to the collector, through an exporter
+// of their own so they outlive the trace exporter when it's replaced.
+func startMetrics(ctx context.Context, cfg otelConfig, res *resource.Resource) error {
+	driver, err := telemetry.NewOTLPDriver(cfg.Exporter.Protocol, cfg.Exporter.Endpoint)
+	if err != nil {
+		return err
+	}
+	exporter, err := otlp.NewExporter(ctx, driver)
+	if err != nil {
+		return fmt.Errorf("failed to create metric exporter: %w", err)
+	}
+	pusher := controller.New(
+		metricprocessor.New(newMetricSelector(), exporter),
+		controller.WithPusher(exporter),
+		controller.WithResource(res),
+		controller.WithCollectPeriod(10*time.Second),
+		controller.WithPushTimeout(cfg.Exporter.Timeout),
+	)
+	if err := pusher.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start metric controller: %w", err)
+	}
+	global.SetMeterProvider(pusher.MeterProvider())
+	meterShutdown = func(ctx context.Context) error {
+		err := pusher.Stop(ctx)
+		return errors.Join(err, exporter.Shutdown(ctx))
+	}
+	return nil
+}
+
+// logErrorHandler logs errors from the SDK, such as failed exports while the
+// collector connection is being re-established.
+type logErrorHandler struct{}
+
+func (logErrorHandler) Handle(err error) {
+	log.Printf("OpenTelemetry error: %v", err)
+}
+
+// selfTest exports a single span straight through the exporter, so problems
+// reaching the collector show up at startup rather than on the first real
+// request. It bypasses the span processor, which would only hand export
+// errors to the global error handler.
+func selfTest(ctx context.Context, exporter exporttrace.SpanExporter, res *resource.Resource) {
+	recorder := &telemetry.SpanRecorder{}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithSpanProcessor(recorder),
+	)
+	_, span := provider.Tracer("go-server").Start(ctx, "startup.selftest")
+	span.End()
+
+	var spans []*exporttrace.SpanSnapshot
+	for _, s := range recorder.Ended() {
+		spans = append(spans, s.Snapshot())
+	}
+	exportCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := exporter.ExportSpans(exportCtx, spans); err != nil {
+		log.Printf("Telemetry self-test failed: %v", err)
+		return
+	}
+	log.Println("Telemetry self-test span exported")
+}
+
+// Shutdown flushes and stops the meter provider and then the tracer provider.
+// Every provider is shut down even if an earlier one fails.
+func Shutdown(ctx context.Context) error {
+	var errs []error
+	if meterShutdown != nil {
+		errs = append(errs, meterShutdown(ctx))
+		meterShutdown = nil
+	}
+	if tracerShutdown != nil {
+		errs = append(errs, tracerShutdown(ctx))
+		tracerShutdown = nil
+	}
+	return errors.Join(errs...)
+}
+
+// timeoutExporter bounds each export with a deadline, so a collector that
+// accepts the connection but never answers can't stall the batch processor.
+type timeoutExporter struct {
+	exporttrace.SpanExporter
+	timeout time.Duration
+}
+
+func (e timeoutExporter) ExportSpans(ctx context.Context, spans []*exporttrace.SpanSnapshot) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return e.SpanExporter.ExportSpans(ctx, spans)
+}
+
+const defaultBatchTimeout = 5 * time.Second
+
+// batchTimeout returns the batch schedule delay, optionally jittered by up to
+// ±20% so instances started together don't all export in lockstep.
+func batchTimeout(jitter bool) time.Duration {
+	if !jitter {
+		return defaultBatchTimeout
+	}
+	factor := 0.8 + 0.4*rand.Float64()
+	return time.Duration(float64(defaultBatchTimeout) * factor)
+}
+
+func newSpanProcessor(kind string, exporter exporttrace.SpanExporter) (sdktrace.SpanProcessor, error) {
+	switch kind {
+	case "batch":
+		return sdktrace.NewBatchSpanProcessor(
+			exporter,
+			sdktrace.WithBatchTimeout(batchTimeout(envBool("BATCH_TIMEOUT_JITTER", false))),
+			sdktrace.WithMaxExportBatchSize(10),
+		), nil
+	case "simple":
+		return sdktrace.NewSimpleSpanProcessor(exporter), nil
+	}
+	return nil, fmt.Errorf("unknown span processor %q", kind)
 }
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+)
+
+func TestReinitKeepsProviderWhenConfigIsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	t.Setenv("OTEL_TRACES_EXPORTER", "file")
+	t.Setenv("OTEL_FILE_PATH", path)
+	t.Setenv("OTEL_SPAN_PROCESSOR", "simple")
+	t.Setenv("OTEL_SELFTEST", "false")
+	ctx := context.Background()
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown(ctx)
+
+	t.Setenv("OTEL_TRACES_SAMPLER", "bogus")
+	if err := InitOpenTelemetry(ctx); err == nil {
+		t.Fatal("expected an error for an unknown sampler")
+	}
+
+	// The failed re-init must leave the working provider in place.
+	_, span := tracer().Start(ctx, "after.reinit")
+	span.End()
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"after.reinit"`) {
+		t.Errorf("span started after the failed re-init wasn't exported:\n%s", data)
+	}
+}
+
+// batchGoroutines counts the goroutines batch span processors are running.
+func batchGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	n := 0
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, "created by go.opentelemetry.io/otel/sdk/trace.NewBatchSpanProcessor") {
+			n++
+		}
+	}
+	return n
+}
+
+// waitForBatchGoroutines waits briefly for stopped processors' goroutines to
+// exit, and reports whether at most want are left.
+func waitForBatchGoroutines(want int) bool {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if batchGoroutines() <= want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReinitShutsDownPreviousProvider(t *testing.T) {
+	first := filepath.Join(t.TempDir(), "first.jsonl")
+	t.Setenv("OTEL_TRACES_EXPORTER", "file")
+	t.Setenv("OTEL_FILE_PATH", first)
+	t.Setenv("OTEL_SPAN_PROCESSOR", "batch")
+	t.Setenv("OTEL_SELFTEST", "false")
+	ctx := context.Background()
+	// Stop whatever earlier tests left running so only this test's
+	// processors are counted.
+	Shutdown(ctx)
+	waitForBatchGoroutines(0)
+	before := batchGoroutines()
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown(ctx)
+	// The batch processor holds this span until it's flushed.
+	_, span := tracer().Start(ctx, "before.reinit")
+	span.End()
+
+	t.Setenv("OTEL_FILE_PATH", filepath.Join(t.TempDir(), "second.jsonl"))
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"before.reinit"`) {
+		t.Error("re-init didn't shut down the first provider, so its queued span wasn't flushed")
+	}
+	if !waitForBatchGoroutines(before + 1) {
+		t.Errorf("%d batch processor goroutines after re-init, want 1", batchGoroutines()-before)
+	}
+
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !waitForBatchGoroutines(before) {
+		t.Errorf("%d batch processor goroutines left after Shutdown", batchGoroutines()-before)
+	}
+}
+
+func TestReinitKeepsMeterProvider(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "localhost:1")
+	t.Setenv("OTEL_SELFTEST", "false")
+	ctx := context.Background()
+
+	stopped := false
+	meterShutdown = func(context.Context) error {
+		stopped = true
+		return nil
+	}
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stopped {
+		t.Error("re-init stopped the metric controller")
+	}
+	if err := Shutdown(ctx); err != nil {
+		t.Logf("shutdown: %v", err)
+	}
+	if !stopped {
+		t.Error("Shutdown didn't stop the metric controller")
+	}
+}
+
+func TestSpanProcessorFromEnv(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"batch", "*trace.BatchSpanProcessor", false},
+		{"simple", "*trace.SimpleSpanProcessor", false},
+		{"bogus", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("OTEL_SPAN_PROCESSOR", tt.env)
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		processor, err := newSpanProcessor(cfg.SpanProcessor, discardExporter{})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("OTEL_SPAN_PROCESSOR=%s: expected an error", tt.env)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("OTEL_SPAN_PROCESSOR=%s: %v", tt.env, err)
+		}
+		if got := fmt.Sprintf("%T", processor); got != tt.want {
+			t.Errorf("OTEL_SPAN_PROCESSOR=%s: processor is %s, want %s", tt.env, got, tt.want)
+		}
+		processor.Shutdown(context.Background())
+	}
+}
+
+func TestShutdownStopsBothProviders(t *testing.T) {
+	meterErr := errors.New("meter failed")
+	var calls []string
+	meterShutdown = func(context.Context) error {
+		calls = append(calls, "meter")
+		return meterErr
+	}
+	tracerShutdown = func(context.Context) error {
+		calls = append(calls, "tracer")
+		return nil
+	}
+	err := Shutdown(context.Background())
+	if !errors.Is(err, meterErr) {
+		t.Errorf("Shutdown = %v, want the meter's error", err)
+	}
+	if len(calls) != 2 || calls[0] != "meter" || calls[1] != "tracer" {
+		t.Errorf("shutdown calls = %q, want [meter tracer]", calls)
+	}
+}
+
+func TestInitOpenTelemetryRunsSelfTest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	t.Setenv("OTEL_TRACES_EXPORTER", "file")
+	t.Setenv("OTEL_FILE_PATH", path)
+	t.Setenv("OTEL_SELFTEST", "true")
+	ctx := context.Background()
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The self-test span is exported directly, before any request.
+	data, err := ioutil.ReadFile(path)
+	Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"startup.selftest"`) {
+		t.Errorf("self-test span wasn't exported:\n%s", data)
+	}
+}
+
+// failingExporter fails every export.
+type failingExporter struct{}
+
+func (failingExporter) ExportSpans(context.Context, []*exporttrace.SpanSnapshot) error {
+	return errors.New("collector unreachable")
+}
+
+func (failingExporter) Shutdown(context.Context) error { return nil }
+
+func TestSelfTestLogsExportFailures(t *testing.T) {
+	logs := make(chanWriter, 10)
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+	selfTest(context.Background(), failingExporter{}, nil)
+	if line := <-logs; !strings.Contains(line, "self-test failed: collector unreachable") {
+		t.Errorf("logged %q, want the export failure", line)
+	}
+}
+
+func TestBatchTimeoutJitter(t *testing.T) {
+	if got := batchTimeout(false); got != defaultBatchTimeout {
+		t.Fatalf("batchTimeout(false) = %v, want %v", got, defaultBatchTimeout)
+	}
+	min := time.Duration(float64(defaultBatchTimeout) * 0.8)
+	max := time.Duration(float64(defaultBatchTimeout) * 1.2)
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 50; i++ {
+		got := batchTimeout(true)
+		if got < min || got > max {
+			t.Fatalf("batchTimeout(true) = %v, want within [%v, %v]", got, min, max)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("batchTimeout(true) returned the same delay every time")
+	}
+}
+
+// exportedResource initializes OpenTelemetry with the file exporter, ends
+// one span and returns the string attributes of the resource it was
+// exported with.
+func exportedResource(t *testing.T) map[string]string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	t.Setenv("OTEL_TRACES_EXPORTER", "file")
+	t.Setenv("OTEL_FILE_PATH", path)
+	t.Setenv("OTEL_SPAN_PROCESSOR", "simple")
+	t.Setenv("OTEL_SELFTEST", "false")
+	ctx := context.Background()
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, span := tracer().Start(ctx, "resource.probe")
+	span.End()
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot struct {
+		Resource []struct {
+			Key   string
+			Value struct{ Value interface{} }
+		}
+	}
+	if err := json.Unmarshal([]byte(strings.SplitN(string(data), "\n", 2)[0]), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	attrs := map[string]string{}
+	for _, kv := range snapshot.Resource {
+		attrs[kv.Key] = fmt.Sprint(kv.Value.Value)
+	}
+	return attrs
+}
+
+func TestResourceHasStableServiceInstanceID(t *testing.T) {
+	first := exportedResource(t)["service.instance.id"]
+	if first == "" {
+		t.Fatal("service.instance.id missing from the resource")
+	}
+	if second := exportedResource(t)["service.instance.id"]; second != first {
+		t.Errorf("service.instance.id changed from %q to %q on reinit", first, second)
+	}
+
+	t.Setenv("OTEL_SERVICE_INSTANCE_ID", "cat-1")
+	if got := loadServiceInstanceID(); got != "cat-1" {
+		t.Errorf("loadServiceInstanceID() = %q, want OTEL_SERVICE_INSTANCE_ID", got)
+	}
+}
+
+func TestResourceRecordsDeploymentLocation(t *testing.T) {
+	attrs := exportedResource(t)
+	for _, key := range []string{"cloud.region", "cloud.availability_zone"} {
+		if v, found := attrs[key]; found {
+			t.Errorf("%s = %q with no deployment location set", key, v)
+		}
+	}
+
+	t.Setenv("DEPLOY_REGION", "us-east-1")
+	t.Setenv("DEPLOY_ZONE", "us-east-1b")
+	attrs = exportedResource(t)
+	if got := attrs["cloud.region"]; got != "us-east-1" {
+		t.Errorf("cloud.region = %q, want %q", got, "us-east-1")
+	}
+	if got := attrs["cloud.availability_zone"]; got != "us-east-1b" {
+		t.Errorf("cloud.availability_zone = %q, want %q", got, "us-east-1b")
+	}
+}
+
+func TestInitOpenTelemetryExportsOverHTTPJSON(t *testing.T) {
+	received := make(chan string, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/v1/traces" && r.Header.Get("Content-Type") == "application/json" {
+			received <- string(body)
+		}
+	}))
+	defer collector.Close()
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", collector.URL+"/v1/traces")
+	t.Setenv("OTEL_SPAN_PROCESSOR", "simple")
+	t.Setenv("OTEL_SELFTEST", "false")
+	ctx := context.Background()
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown(ctx)
+	_, span := tracer().Start(ctx, "json.probe")
+	span.End()
+	select {
+	case body := <-received:
+		if !strings.Contains(body, `"name":"json.probe"`) {
+			t.Errorf("export is missing the span: %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no OTLP/JSON export reached the collector")
+	}
+}
+
+func TestResourceRecordsVCSRevision(t *testing.T) {
+	defer func(old func() (*debug.BuildInfo, bool)) { readBuildInfo = old }(readBuildInfo)
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{Settings: []debug.BuildSetting{
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "0123abcd"},
+		}}, true
+	}
+	attrs := exportedResource(t)
+	for _, key := range []string{"service.version", "vcs.revision"} {
+		if got := attrs[key]; got != "0123abcd" {
+			t.Errorf("%s = %q, want the build's revision", key, got)
+		}
+	}
+
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return nil, false }
+	attrs = exportedResource(t)
+	if v, found := attrs["vcs.revision"]; found {
+		t.Errorf("vcs.revision = %q without build info", v)
+	}
+}
+
+// deadlineExporter records the deadline of the context each export gets.
+type deadlineExporter struct {
+	discardExporter
+	remaining *time.Duration
+}
+
+func (e deadlineExporter) ExportSpans(ctx context.Context, _ []*exporttrace.SpanSnapshot) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		*e.remaining = time.Until(deadline)
+	}
+	return nil
+}
+
+func TestTimeoutExporterBoundsExports(t *testing.T) {
+	var remaining time.Duration
+	exporter := timeoutExporter{deadlineExporter{remaining: &remaining}, 750 * time.Millisecond}
+	if err := exporter.ExportSpans(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if remaining <= 0 || remaining > 750*time.Millisecond {
+		t.Errorf("export had %v left before its deadline, want up to 750ms", remaining)
+	}
+}
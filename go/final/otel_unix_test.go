@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// rawExports is a stand-in collector that accepts every RPC and keeps the
+// encoded request messages.
+type rawExports struct {
+	mu       sync.Mutex
+	requests [][]byte
+}
+
+func (e *rawExports) handle(_ interface{}, stream grpc.ServerStream) error {
+	// Empty keeps the fields it doesn't know, so the request bytes survive.
+	var req emptypb.Empty
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	raw, err := proto.Marshal(&req)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.requests = append(e.requests, raw)
+	e.mu.Unlock()
+	return stream.SendMsg(&emptypb.Empty{})
+}
+
+func (e *rawExports) contains(s string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, raw := range e.requests {
+		if bytes.Contains(raw, []byte(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInitOpenTelemetryExportsOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "otlp.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports := &rawExports{}
+	server := grpc.NewServer(grpc.UnknownServiceHandler(exports.handle))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "unix://"+path)
+	ctx := context.Background()
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, span := tracer().Start(ctx, "over.unix")
+	span.End()
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !exports.contains("over.unix") {
+		t.Error("span wasn't exported over the socket")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// receivedSpan is a span as seen by otlpReceiver.
+type receivedSpan struct {
+	ServiceName string
+	Name        string
+}
+
+// otlpReceiver is a minimal in-process OTLP gRPC collector. It decodes just
+// enough of each trace export to record span names and service names, and
+// accepts and discards metric exports.
+type otlpReceiver struct {
+	addr string
+	// stop shuts the receiver down, dropping any open connections.
+	stop func()
+
+	mu       sync.Mutex
+	received []receivedSpan
+}
+
+// startOTLPReceiver serves an otlpReceiver on a local port until the test
+// ends.
+func startOTLPReceiver(t *testing.T) *otlpReceiver {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveOTLPReceiver(t, lis, lis.Addr().String())
+}
+
+// serveOTLPReceiver serves an otlpReceiver on lis until the test ends. addr
+// is the endpoint exporters should use to reach it.
+func serveOTLPReceiver(t *testing.T, lis net.Listener, addr string) *otlpReceiver {
+	s := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}))
+	r := &otlpReceiver{addr: addr, stop: s.Stop}
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "opentelemetry.proto.collector.trace.v1.TraceService",
+		HandlerType: (*interface{})(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Export", Handler: r.exportTraces}},
+	}, r)
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "opentelemetry.proto.collector.metrics.v1.MetricsService",
+		HandlerType: (*interface{})(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Export", Handler: discardExport}},
+	}, r)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return r
+}
+
+func (r *otlpReceiver) spans() []receivedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedSpan(nil), r.received...)
+}
+
+func (r *otlpReceiver) exportTraces(_ interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	var req []byte
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	var spans []receivedSpan
+	// ExportTraceServiceRequest.resource_spans = 1
+	err := protoFields(req, func(num protowire.Number, resourceSpans []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var serviceName string
+		var names []string
+		err := protoFields(resourceSpans, func(num protowire.Number, v []byte) error {
+			switch num {
+			case 1: // ResourceSpans.resource
+				return protoFields(v, func(num protowire.Number, kv []byte) error {
+					if num != 1 { // Resource.attributes
+						return nil
+					}
+					key, value, err := stringKeyValue(kv)
+					if key == "service.name" {
+						serviceName = value
+					}
+					return err
+				})
+			case 2: // ResourceSpans.instrumentation_library_spans
+				return protoFields(v, func(num protowire.Number, span []byte) error {
+					if num != 2 { // InstrumentationLibrarySpans.spans
+						return nil
+					}
+					return protoFields(span, func(num protowire.Number, v []byte) error {
+						if num == 5 { // Span.name
+							names = append(names, string(v))
+						}
+						return nil
+					})
+				})
+			}
+			return nil
+		})
+		for _, name := range names {
+			spans = append(spans, receivedSpan{ServiceName: serviceName, Name: name})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.received = append(r.received, spans...)
+	r.mu.Unlock()
+	return []byte{}, nil
+}
+
+func discardExport(_ interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	var req []byte
+	return []byte{}, dec(&req)
+}
+
+// stringKeyValue decodes a KeyValue whose value is a string.
+func stringKeyValue(kv []byte) (key, value string, err error) {
+	err = protoFields(kv, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1: // KeyValue.key
+			key = string(v)
+		case 2: // KeyValue.value
+			return protoFields(v, func(num protowire.Number, v []byte) error {
+				if num == 1 { // AnyValue.string_value
+					value = string(v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return key, value, err
+}
+
+// protoFields calls fn with each length-delimited field in b and skips the
+// rest.
+func protoFields(b []byte, fn func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err := fn(num, v); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// rawCodec passes message bytes through untouched, so the receiver doesn't
+// need the generated OTLP types.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) { return v.([]byte), nil }
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+func (rawCodec) Name() string { return "proto" }
+
+func TestInitOpenTelemetryExportsOverGRPC(t *testing.T) {
+	receiver := startOTLPReceiver(t)
+	t.Setenv("OTEL_SERVICE_NAME", "cats-e2e")
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", receiver.addr)
+	t.Setenv("OTEL_SELFTEST", "false")
+	useUpstream(t, mockUpstream{})
+	ctx := context.Background()
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown(ctx)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(otelgin.Middleware("go-server", otelgin.WithTracerProvider(priorityTracerProvider{activityTypeTracerProvider{otel.GetTracerProvider()}})))
+	router.POST("/getActivity", handleForm)
+	req := httptest.NewRequest(http.MethodPost, "/getActivity", strings.NewReader(url.Values{"type": {"relaxation"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", w.Code, w.Body.String())
+	}
+
+	// Shutting down flushes the batch processor and the metric controller.
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, span := range receiver.spans() {
+		got[span.Name] = span.ServiceName
+	}
+	for _, name := range []string{"/getActivity", "getActivityWithParams"} {
+		serviceName, ok := got[name]
+		if !ok {
+			t.Errorf("span %q wasn't exported; got %v", name, got)
+			continue
+		}
+		if serviceName != "cats-e2e" {
+			t.Errorf("span %q has service.name %q, want cats-e2e", name, serviceName)
+		}
+	}
+}
+
+func TestOTLPExportsResumeAfterCollectorRestart(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	first := serveOTLPReceiver(t, lis, addr)
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", addr)
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_TIMEOUT", "500")
+	t.Setenv("OTEL_SPAN_PROCESSOR", "simple")
+	t.Setenv("OTEL_SELFTEST", "false")
+	ctx := context.Background()
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown(ctx)
+
+	_, span := tracer().Start(ctx, "before.restart")
+	span.End()
+	if len(first.spans()) == 0 {
+		t.Fatal("span wasn't exported before the restart")
+	}
+
+	first.stop()
+	_, span = tracer().Start(ctx, "while.down")
+	span.End()
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := serveOTLPReceiver(t, lis, addr)
+
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		_, span := tracer().Start(ctx, "after.restart")
+		span.End()
+		for _, span := range second.spans() {
+			if span.Name == "after.restart" {
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("exports never resumed after the collector restarted")
+}
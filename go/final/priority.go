@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const priorityKey = attribute.Key("priority")
+
+type priorityContextKey struct{}
+
+// PriorityMiddleware stores the X-Priority header (high or low) in the request
+// context. It must run before otelgin so the priority is known when the
+// server span starts.
+func PriorityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch p := strings.ToLower(c.GetHeader("X-Priority")); p {
+		case "high", "low":
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), priorityContextKey{}, p))
+		}
+		c.Next()
+	}
+}
+
+// priorityTracerProvider hands out tracers that add the request priority to
+// the start attributes of each span, where prioritySampler can see it.
+type priorityTracerProvider struct {
+	oteltrace.TracerProvider
+}
+
+func (tp priorityTracerProvider) Tracer(name string, opts ...oteltrace.TracerOption) oteltrace.Tracer {
+	return priorityTracer{tp.TracerProvider.Tracer(name, opts...)}
+}
+
+type priorityTracer struct {
+	oteltrace.Tracer
+}
+
+func (t priorityTracer) Start(ctx context.Context, name string, opts ...oteltrace.SpanOption) (context.Context, oteltrace.Span) {
+	if p, ok := ctx.Value(priorityContextKey{}).(string); ok {
+		opts = append(opts, oteltrace.WithAttributes(priorityKey.String(p)))
+	}
+	return t.Tracer.Start(ctx, name, opts...)
+}
+
+// prioritySampler samples every high priority span and drops every low
+// priority one, deferring to fallback for the rest.
+type prioritySampler struct {
+	fallback sdktrace.Sampler
+}
+
+func (s prioritySampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	for _, kv := range p.Attributes {
+		if kv.Key != priorityKey {
+			continue
+		}
+		switch kv.Value.AsString() {
+		case "high":
+			return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample}
+		case "low":
+			return sdktrace.SamplingResult{Decision: sdktrace.Drop}
+		}
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s prioritySampler) Description() string {
+	return "PrioritySampler{" + s.fallback.Description() + "}"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestPrioritySampling(t *testing.T) {
+	cfg := otelConfig{}
+	cfg.Sampler.Name = "traceidratio"
+	cfg.Sampler.Arg = 0
+	sampler, err := cfg.sampler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		params sdktrace.SamplingParameters
+		want   sdktrace.SamplingDecision
+	}{
+		{
+			name:   "high priority root",
+			params: sdktrace.SamplingParameters{TraceID: testTraceID, Attributes: []attribute.KeyValue{priorityKey.String("high")}},
+			want:   sdktrace.RecordAndSample,
+		},
+		{
+			name:   "root without priority",
+			params: sdktrace.SamplingParameters{TraceID: testTraceID},
+			want:   sdktrace.Drop,
+		},
+		{
+			name:   "client span under a high priority span",
+			params: sdktrace.SamplingParameters{TraceID: testTraceID, ParentContext: parentContext(true)},
+			want:   sdktrace.RecordAndSample,
+		},
+		{
+			name:   "client span under a low priority span",
+			params: sdktrace.SamplingParameters{TraceID: testTraceID, ParentContext: parentContext(false)},
+			want:   sdktrace.Drop,
+		},
+		{
+			name: "high priority request with an unsampled remote parent",
+			params: sdktrace.SamplingParameters{
+				TraceID:         testTraceID,
+				ParentContext:   parentContext(false),
+				HasRemoteParent: true,
+				Attributes:      []attribute.KeyValue{priorityKey.String("high")},
+			},
+			want: sdktrace.RecordAndSample,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sampler.ShouldSample(tt.params).Decision; got != tt.want {
+				t.Errorf("decision = %s, want %s", decisionName(got), decisionName(tt.want))
+			}
+		})
+	}
+}
+
+func TestPriorityMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for header, want := range map[string]string{"HIGH": "high", "low": "low", "urgent": ""} {
+		router := gin.New()
+		router.Use(PriorityMiddleware())
+		var got string
+		router.GET("/", func(c *gin.Context) {
+			got, _ = c.Request.Context().Value(priorityContextKey{}).(string)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Priority", header)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if got != want {
+			t.Errorf("X-Priority %q: priority = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// notFoundFilter drops server spans for requests that didn't match a route
+// and returned 404, which are mostly noise from bots and scanners.
+type notFoundFilter struct {
+	sdktrace.SpanProcessor
+}
+
+func (f notFoundFilter) OnEnd(s sdktrace.ReadOnlySpan) {
+	if s.SpanKind() == oteltrace.SpanKindServer && isUnroutedNotFound(s) {
+		return
+	}
+	f.SpanProcessor.OnEnd(s)
+}
+
+func isUnroutedNotFound(s sdktrace.ReadOnlySpan) bool {
+	notFound := false
+	for _, kv := range s.Attributes() {
+		switch kv.Key {
+		case semconv.HTTPRouteKey:
+			return false
+		case semconv.HTTPStatusCodeKey:
+			notFound = kv.Value.AsInt64() == http.StatusNotFound
+		}
+	}
+	return notFound
+}
+
+// orphanFilter drops spans whose parent wasn't sampled, which happens when a
+// child's sampler decides differently from its parent's. Exporting them would
+// leave orphans with no parent in the backend. Descendants of a dropped span
+// are dropped too: children usually end before their parents, so the filter
+// decides when each span starts. Error spans kept by errorKeepingProcessor
+// are let through on purpose.
+type orphanFilter struct {
+	sdktrace.SpanProcessor
+
+	mu      sync.Mutex
+	orphans map[oteltrace.SpanID]struct{}
+}
+
+func newOrphanFilter(next sdktrace.SpanProcessor) *orphanFilter {
+	return &orphanFilter{SpanProcessor: next, orphans: map[oteltrace.SpanID]struct{}{}}
+}
+
+func (f *orphanFilter) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	p := s.Parent()
+	f.mu.Lock()
+	if _, orphaned := f.orphans[p.SpanID]; p.IsValid() && (orphaned || !p.IsSampled()) {
+		f.orphans[s.SpanContext().SpanID] = struct{}{}
+	}
+	f.mu.Unlock()
+	f.SpanProcessor.OnStart(parent, s)
+}
+
+func (f *orphanFilter) OnEnd(s sdktrace.ReadOnlySpan) {
+	id := s.SpanContext().SpanID
+	f.mu.Lock()
+	_, orphan := f.orphans[id]
+	delete(f.orphans, id)
+	f.mu.Unlock()
+	if _, kept := s.(sampledSpan); orphan && !kept {
+		return
+	}
+	f.SpanProcessor.OnEnd(s)
+}
+
+// errorKeepingProcessor exports error spans even when they weren't sampled.
+// The sampler can't know a span will fail when it starts, so this relies on
+// recordOnlySampler keeping dropped spans recording until they end.
+type errorKeepingProcessor struct {
+	sdktrace.SpanProcessor
+}
+
+func (p errorKeepingProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() && s.StatusCode() == codes.Error {
+		s = sampledSpan{s}
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// sampledSpan marks an unsampled span as sampled so downstream processors
+// export it. The batch processor only looks at the snapshot, so that's marked
+// too.
+type sampledSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() oteltrace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	sc.TraceFlags |= oteltrace.FlagsSampled
+	return sc
+}
+
+func (s sampledSpan) Snapshot() *exporttrace.SpanSnapshot {
+	snapshot := s.ReadOnlySpan.Snapshot()
+	snapshot.SpanContext.TraceFlags |= oteltrace.FlagsSampled
+	return snapshot
+}
+
+// recordingTracerProvider hands out tracers that start every span with
+// WithRecord. The SDK leaves the name, timestamps and attributes of unsampled
+// spans empty unless they're started that way, which would leave
+// errorKeepingProcessor nothing worth exporting.
+type recordingTracerProvider struct {
+	oteltrace.TracerProvider
+}
+
+func (tp recordingTracerProvider) Tracer(name string, opts ...oteltrace.TracerOption) oteltrace.Tracer {
+	return recordingTracer{tp.TracerProvider.Tracer(name, opts...)}
+}
+
+type recordingTracer struct {
+	oteltrace.Tracer
+}
+
+func (t recordingTracer) Start(ctx context.Context, name string, opts ...oteltrace.SpanOption) (context.Context, oteltrace.Span) {
+	return t.Tracer.Start(ctx, name, append(opts, oteltrace.WithRecord())...)
+}
+
+// requiredHTTPAttributes lists the semantic convention attributes HTTP spans
+// of each kind must have.
+var requiredHTTPAttributes = map[oteltrace.SpanKind][]attribute.Key{
+	oteltrace.SpanKindClient: {semconv.HTTPMethodKey, semconv.HTTPURLKey},
+	oteltrace.SpanKindServer: {semconv.HTTPMethodKey, semconv.HTTPStatusCodeKey},
+}
+
+// semconvValidator logs a warning for HTTP spans missing required semantic
+// convention attributes. It's meant for development, not production.
+type semconvValidator struct {
+	sdktrace.SpanProcessor
+}
+
+func (v semconvValidator) OnEnd(s sdktrace.ReadOnlySpan) {
+	if missing := missingHTTPAttributes(s); len(missing) > 0 {
+		log.Printf("Span %q (%s) is missing semantic convention attributes: %s",
+			s.Name(), s.SpanKind(), strings.Join(missing, ", "))
+	}
+	v.SpanProcessor.OnEnd(s)
+}
+
+func missingHTTPAttributes(s sdktrace.ReadOnlySpan) []string {
+	required, ok := requiredHTTPAttributes[s.SpanKind()]
+	if !ok {
+		return nil
+	}
+	present := map[attribute.Key]bool{}
+	isHTTP := false
+	for _, kv := range s.Attributes() {
+		present[kv.Key] = true
+		isHTTP = isHTTP || strings.HasPrefix(string(kv.Key), "http.")
+	}
+	if !isHTTP {
+		return nil
+	}
+	var missing []string
+	for _, key := range required {
+		if !present[key] {
+			missing = append(missing, string(key))
+		}
+	}
+	return missing
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/codes"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// memoryExporter keeps the spans exported to it.
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*exporttrace.SpanSnapshot
+}
+
+func (e *memoryExporter) ExportSpans(_ context.Context, spans []*exporttrace.SpanSnapshot) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error { return nil }
+
+func (e *memoryExporter) names() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var names []string
+	for _, s := range e.spans {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestNotFoundFilterDropsUnroutedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	exporter := &memoryExporter{}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(notFoundFilter{sdktrace.NewSimpleSpanProcessor(exporter)}),
+	)
+	router := gin.New()
+	router.Use(otelgin.Middleware("go-server", otelgin.WithTracerProvider(provider)))
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "hello") })
+	router.GET("/cats/:name", func(c *gin.Context) { c.String(http.StatusNotFound, "no such cat") })
+	for _, path := range []string{"/wp-login.php", "/", "/cats/garfield"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// A 404 from a matched route is a real answer, and is kept.
+	names := exporter.names()
+	if len(names) != 2 || names[0] != "/" || names[1] != "/cats/:name" {
+		t.Errorf("exported %q, want [/ /cats/:name]", names)
+	}
+}
+
+func TestErrorKeepingProcessorExportsThroughBatcher(t *testing.T) {
+	exporter := &memoryExporter{}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: recordOnlySampler{sdktrace.NeverSample()}}),
+		sdktrace.WithSpanProcessor(errorKeepingProcessor{sdktrace.NewBatchSpanProcessor(exporter)}),
+	)
+	tracer := recordingTracerProvider{provider}.Tracer("test")
+	_, ok := tracer.Start(context.Background(), "ok")
+	ok.End()
+	_, failed := tracer.Start(context.Background(), "failed")
+	failed.SetStatus(codes.Error, "boom")
+	failed.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	names := exporter.names()
+	if len(names) != 1 || names[0] != "failed" {
+		t.Fatalf("exported %q, want only the failed span", names)
+	}
+	if !exporter.spans[0].SpanContext.IsSampled() {
+		t.Error("exported error span isn't marked sampled")
+	}
+}
+
+// coinSampler samples each span on its own with the given probability,
+// ignoring the parent's decision.
+type coinSampler struct {
+	rand  *rand.Rand
+	ratio float64
+}
+
+func (s coinSampler) ShouldSample(sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if s.rand.Float64() < s.ratio {
+		return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample}
+	}
+	return sdktrace.SamplingResult{Decision: sdktrace.Drop}
+}
+
+func (s coinSampler) Description() string { return "CoinSampler" }
+
+// exportThreeLevelTraces exports n traces of a root, a child and a
+// grandchild, sampled independently, through wrap's processor.
+func exportThreeLevelTraces(t *testing.T, n int, wrap func(sdktrace.SpanProcessor) sdktrace.SpanProcessor) []*exporttrace.SpanSnapshot {
+	t.Helper()
+	exporter := &memoryExporter{}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: coinSampler{rand.New(rand.NewSource(1)), 0.3}}),
+		sdktrace.WithSpanProcessor(wrap(sdktrace.NewBatchSpanProcessor(exporter))),
+	)
+	tracer := provider.Tracer("test")
+	for i := 0; i < n; i++ {
+		ctx, root := tracer.Start(context.Background(), "root")
+		ctx, child := tracer.Start(ctx, "child")
+		_, grandchild := tracer.Start(ctx, "grandchild")
+		grandchild.End()
+		child.End()
+		root.End()
+	}
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return exporter.spans
+}
+
+func orphans(spans []*exporttrace.SpanSnapshot) int {
+	exported := map[oteltrace.SpanID]bool{}
+	for _, s := range spans {
+		exported[s.SpanContext.SpanID] = true
+	}
+	n := 0
+	for _, s := range spans {
+		if s.ParentSpanID.IsValid() && !exported[s.ParentSpanID] {
+			n++
+		}
+	}
+	return n
+}
+
+func TestOrphanFilterDropsOrphans(t *testing.T) {
+	unfiltered := exportThreeLevelTraces(t, 200, func(p sdktrace.SpanProcessor) sdktrace.SpanProcessor { return p })
+	if orphans(unfiltered) == 0 {
+		t.Fatal("independent sampling exported no orphans to filter")
+	}
+
+	spans := exportThreeLevelTraces(t, 200, func(p sdktrace.SpanProcessor) sdktrace.SpanProcessor { return newOrphanFilter(p) })
+	if len(spans) == 0 {
+		t.Fatal("no spans were exported")
+	}
+	if n := orphans(spans); n > 0 {
+		t.Errorf("exported %d of %d spans without their parent", n, len(spans))
+	}
+}
+
+func TestSemconvValidatorWarnsAboutMissingAttributes(t *testing.T) {
+	logs := make(chanWriter, 10)
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+	exporter := &memoryExporter{}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(semconvValidator{sdktrace.NewSimpleSpanProcessor(exporter)}),
+	)
+	tracer := provider.Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "complete", oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(semconv.HTTPMethodKey.String("GET"), semconv.HTTPURLKey.String("https://upstream.test/")))
+	span.End()
+	_, span = tracer.Start(context.Background(), "incomplete", oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(semconv.HTTPMethodKey.String("GET")))
+	span.End()
+
+	select {
+	case line := <-logs:
+		if !strings.Contains(line, `"incomplete"`) || !strings.Contains(line, "http.url") {
+			t.Errorf("warning = %q, want one naming the span and http.url", line)
+		}
+	default:
+		t.Fatal("no warning logged for a client span without http.url")
+	}
+	if len(logs) > 0 {
+		t.Errorf("unexpected warning %q", <-logs)
+	}
+	if len(exporter.names()) != 2 {
+		t.Errorf("exported %q, want both spans", exporter.names())
+	}
+}
+
+func TestGetActivitySpanHasRequiredHTTPAttributes(t *testing.T) {
+	_, recorder := NewTestProvider()
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	if _, err := getActivityWithParams(context.Background(), "diy"); err != nil {
+		t.Fatal(err)
+	}
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if missing := missingHTTPAttributes(span); len(missing) > 0 {
+		t.Errorf("getActivityWithParams span is missing %v", missing)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// resourceEnvVars feed the resource built by InitOpenTelemetry.
+var resourceEnvVars = []string{"OTEL_SERVICE_NAME", "OTEL_RESOURCE_ATTRIBUTES", "DEPLOY_REGION", "DEPLOY_ZONE"}
+
+// startResourceRefresh checks the resource configuration every interval and,
+// if it's changed, rebuilds the tracer provider so new spans carry the
+// updated resource. A process's environment can't be changed from outside,
+// so in practice changes arrive through the file named by OTEL_CONFIG_FILE.
+// Spans still open when the old provider shuts down are lost, and metrics
+// keep the resource they started with. The returned function stops the
+// refresh.
+func startResourceRefresh(ctx context.Context, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	last := resourceConfig()
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			current := resourceConfig()
+			if current == last {
+				continue
+			}
+			log.Println("Resource configuration changed, reinitializing OpenTelemetry")
+			if err := InitOpenTelemetry(ctx); err != nil {
+				log.Printf("Failed to reinitialize OpenTelemetry: %v", err)
+				continue
+			}
+			last = current
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// resourceConfig returns the resource environment variables and the
+// contents of the config file, for comparison between checks.
+func resourceConfig() string {
+	values := make([]string, len(resourceEnvVars))
+	for i, name := range resourceEnvVars {
+		values[i] = name + "=" + os.Getenv(name)
+	}
+	if path, ok := os.LookupEnv("OTEL_CONFIG_FILE"); ok {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("Failed to read %s: %v", path, err)
+		}
+		values = append(values, string(data))
+	}
+	return strings.Join(values, "\n")
+}
+
+func resourceRefreshInterval() (time.Duration, bool) {
+	v, ok := os.LookupEnv("RESOURCE_REFRESH_INTERVAL")
+	if !ok {
+		return 0, false
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Ignoring invalid RESOURCE_REFRESH_INTERVAL %q", v)
+		return 0, false
+	}
+	return d, true
+}
+
+// globalTracerProvider hands out tracers that look up the global provider on
+// every span, so instrumentation set up once keeps working after the
+// providers are rebuilt.
+type globalTracerProvider struct{}
+
+func (globalTracerProvider) Tracer(name string, opts ...oteltrace.TracerOption) oteltrace.Tracer {
+	return globalTracer{name: name, opts: opts}
+}
+
+type globalTracer struct {
+	name string
+	opts []oteltrace.TracerOption
+}
+
+func (t globalTracer) Start(ctx context.Context, name string, opts ...oteltrace.SpanOption) (context.Context, oteltrace.Span) {
+	return otel.GetTracerProvider().Tracer(t.name, t.opts...).Start(ctx, name, opts...)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResourceRefreshPicksUpConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	tracesPath := filepath.Join(dir, "traces.jsonl")
+	configPath := filepath.Join(dir, "otel.yaml")
+	writeConfig := func(serviceName string) {
+		config := fmt.Sprintf("service_name: %s\nspan_processor: simple\nselftest: false\nexporter:\n  name: file\n  path: %s\n", serviceName, tracesPath)
+		if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("cats-before")
+	t.Setenv("OTEL_CONFIG_FILE", configPath)
+	ctx := context.Background()
+	if err := InitOpenTelemetry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown(ctx)
+
+	stop := startResourceRefresh(ctx, 10*time.Millisecond)
+	defer stop()
+	writeConfig("cats-after")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_, span := tracer().Start(ctx, "probe")
+		span.End()
+		data, err := ioutil.ReadFile(tracesPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "cats-after") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, _ := ioutil.ReadFile(tracesPath)
+	t.Fatalf("spans never carried the service name from the updated config file:\n%.2000s", data)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// retryBudget is a token bucket shared by every request. Each retry spends a
+// token, so a struggling upstream can't be hit with a storm of retries.
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	refill float64 // tokens per second
+	last   time.Time
+}
+
+var upstreamRetryBudget = newRetryBudget(10, 1)
+
+var _ = meter.NewFloat64ValueObserver(
+	"upstream.retry_budget",
+	func(_ context.Context, result metric.Float64ObserverResult) {
+		result.Observe(upstreamRetryBudget.level())
+	},
+	metric.WithDescription("Retry tokens currently available for upstream calls"),
+)
+
+func newRetryBudget(max, refill float64) *retryBudget {
+	return &retryBudget{tokens: max, max: max, refill: refill, last: time.Now()}
+}
+
+// take spends a token if one is available.
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *retryBudget) level() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked()
+	return b.tokens
+}
+
+func (b *retryBudget) refillLocked() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.refill
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.last = now
+}
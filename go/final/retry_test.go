@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryBudgetStopsRetriesWhenExhausted(t *testing.T) {
+	_, recorder := NewTestProvider()
+	old := upstreamRetryBudget
+	upstreamRetryBudget = newRetryBudget(3, 0)
+	defer func() { upstreamRetryBudget = old }()
+	var calls int64
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt64(&calls, 1)
+		return upstreamResponse(req, http.StatusBadGateway, ""), nil
+	}))
+
+	const requests = 5
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Distinct types, so the requests don't share a fetch.
+			getActivityWithParams(context.Background(), fmt.Sprintf("budget-%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&calls); got != requests+3 {
+		t.Errorf("upstream called %d times, want %d (one each plus the 3 budgeted retries)", got, requests+3)
+	}
+	exhausted := 0
+	for _, span := range recorder.Ended() {
+		if v, _ := spanAttr(span, "retry.budget_exhausted"); v.AsBool() {
+			exhausted++
+		}
+	}
+	if exhausted == 0 {
+		t.Error("no span recorded retry.budget_exhausted")
+	}
+	if level := upstreamRetryBudget.level(); level >= 1 {
+		t.Errorf("budget level = %v, want it spent", level)
+	}
+}
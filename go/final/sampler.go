@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const activityTypeKey = attribute.Key("activityType")
+
+type activityTypeContextKey struct{}
+
+// maxActivityTypeFormBytes bounds how much of a form body
+// ActivityTypeMiddleware reads looking for the activity type.
+const maxActivityTypeFormBytes = 64 << 10
+
+// ActivityTypeMiddleware stores the type field of a form-encoded request body
+// in the request context, so the root span can be sampled by activity type.
+// It must run before otelgin so the type is known when the root span starts.
+// The body is put back for the handler to read.
+func ActivityTypeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || !strings.HasPrefix(c.ContentType(), "application/x-www-form-urlencoded") {
+			c.Next()
+			return
+		}
+		peeked, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxActivityTypeFormBytes+1))
+		c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(peeked), c.Request.Body), c.Request.Body}
+		if err == nil && len(peeked) <= maxActivityTypeFormBytes {
+			if form, err := url.ParseQuery(string(peeked)); err == nil {
+				if t := form.Get("type"); t != "" {
+					c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), activityTypeContextKey{}, t))
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// activityTypeTracerProvider hands out tracers that add the activity type to
+// the start attributes of root spans, where activityTypeSampler can see it.
+type activityTypeTracerProvider struct {
+	oteltrace.TracerProvider
+}
+
+func (p activityTypeTracerProvider) Tracer(name string, opts ...oteltrace.TracerOption) oteltrace.Tracer {
+	return activityTypeTracer{p.TracerProvider.Tracer(name, opts...)}
+}
+
+type activityTypeTracer struct {
+	oteltrace.Tracer
+}
+
+func (t activityTypeTracer) Start(ctx context.Context, name string, opts ...oteltrace.SpanOption) (context.Context, oteltrace.Span) {
+	if activityType, ok := ctx.Value(activityTypeContextKey{}).(string); ok && !oteltrace.SpanContextFromContext(ctx).IsValid() {
+		opts = append(opts, oteltrace.WithAttributes(activityTypeKey.String(activityType)))
+	}
+	return t.Tracer.Start(ctx, name, opts...)
+}
+
+// readCloser reads from a replacement reader and closes the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// activityTypeSampler applies a per-type sampling ratio to root spans that
+// are started with an activityType attribute, and defers to fallback for
+// other roots. Spans with a parent follow the parent's decision, so a trace
+// is kept or dropped as a whole.
+type activityTypeSampler struct {
+	samplers map[string]sdktrace.Sampler
+	fallback sdktrace.Sampler
+	parent   sdktrace.Sampler
+}
+
+func newActivityTypeSampler(fallback sdktrace.Sampler, ratios map[string]float64) sdktrace.Sampler {
+	s := activityTypeSampler{
+		samplers: map[string]sdktrace.Sampler{},
+		fallback: fallback,
+		parent:   sdktrace.ParentBased(fallback),
+	}
+	for t, ratio := range ratios {
+		s.samplers[t] = sdktrace.TraceIDRatioBased(ratio)
+	}
+	return s
+}
+
+func (s activityTypeSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if p.ParentContext.IsValid() {
+		return s.parent.ShouldSample(p)
+	}
+	for _, kv := range p.Attributes {
+		if kv.Key != activityTypeKey {
+			continue
+		}
+		if sampler, ok := s.samplers[kv.Value.AsString()]; ok {
+			return sampler.ShouldSample(p)
+		}
+		break
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s activityTypeSampler) Description() string {
+	return fmt.Sprintf("ActivityTypeSampler{types:%d,fallback:%s}", len(s.samplers), s.fallback.Description())
+}
+
+// warmupSampler samples the first n traces in full, so a demo always has
+// traces to look at, and defers to fallback after that.
+type warmupSampler struct {
+	n        int64
+	started  *int64
+	traces   *forcedTraces
+	fallback sdktrace.Sampler
+}
+
+func newWarmupSampler(fallback sdktrace.Sampler, n int) sdktrace.Sampler {
+	return warmupSampler{
+		n:        int64(n),
+		started:  new(int64),
+		traces:   newForcedTraces(n, 10*time.Minute),
+		fallback: fallback,
+	}
+}
+
+func (s warmupSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if !p.ParentContext.IsValid() && atomic.LoadInt64(s.started) < s.n {
+		if atomic.AddInt64(s.started, 1) <= s.n {
+			s.traces.add(p.TraceID)
+		}
+	}
+	if s.traces.contains(p.TraceID) {
+		return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample}
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s warmupSampler) Description() string {
+	return fmt.Sprintf("WarmupSampler{n:%d,fallback:%s}", s.n, s.fallback.Description())
+}
+
+// decisionRecordingSampler adds the wrapped sampler's decision and
+// description to the attributes of root spans.
+type decisionRecordingSampler struct {
+	sdktrace.Sampler
+}
+
+func (s decisionRecordingSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	result := s.Sampler.ShouldSample(p)
+	if p.ParentContext.IsValid() {
+		return result
+	}
+	attrs := make([]attribute.KeyValue, 0, len(result.Attributes)+2)
+	attrs = append(attrs, result.Attributes...)
+	attrs = append(attrs,
+		attribute.String("sampling.decision", decisionName(result.Decision)),
+		attribute.String("sampling.sampler", s.Sampler.Description()),
+	)
+	result.Attributes = attrs
+	return result
+}
+
+func decisionName(d sdktrace.SamplingDecision) string {
+	switch d {
+	case sdktrace.Drop:
+		return "Drop"
+	case sdktrace.RecordOnly:
+		return "RecordOnly"
+	case sdktrace.RecordAndSample:
+		return "RecordAndSample"
+	}
+	return "Unknown"
+}
+
+// loggingSampler logs the decision the wrapped sampler makes for each root
+// span.
+type loggingSampler struct {
+	sdktrace.Sampler
+}
+
+func (s loggingSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	result := s.Sampler.ShouldSample(p)
+	if p.ParentContext.IsValid() {
+		return result
+	}
+	route := p.Name
+	for _, kv := range p.Attributes {
+		if kv.Key == semconv.HTTPRouteKey {
+			route = kv.Value.AsString()
+		}
+	}
+	log.Printf("sampling: trace_id=%s route=%q decision=%s", p.TraceID, route, decisionName(result.Decision))
+	return result
+}
+
+// recordOnlySampler records spans the wrapped sampler would drop, without
+// sampling them, so errorKeepingProcessor can still see how they end.
+type recordOnlySampler struct {
+	sdktrace.Sampler
+}
+
+func (s recordOnlySampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	result := s.Sampler.ShouldSample(p)
+	if result.Decision == sdktrace.Drop {
+		result.Decision = sdktrace.RecordOnly
+	}
+	return result
+}
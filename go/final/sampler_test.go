@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+var (
+	testTraceID = oteltrace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+	testSpanID  = oteltrace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+)
+
+func parentContext(sampled bool) oteltrace.SpanContext {
+	sc := oteltrace.SpanContext{TraceID: testTraceID, SpanID: testSpanID}
+	if sampled {
+		sc.TraceFlags = oteltrace.FlagsSampled
+	}
+	return sc
+}
+
+func TestActivityTypeSamplerRatios(t *testing.T) {
+	sampler := newActivityTypeSampler(sdktrace.AlwaysSample(), map[string]float64{"charity": 0})
+
+	tests := []struct {
+		name   string
+		params sdktrace.SamplingParameters
+		want   sdktrace.SamplingDecision
+	}{
+		{
+			name:   "root with ratio",
+			params: sdktrace.SamplingParameters{TraceID: testTraceID, Attributes: []attribute.KeyValue{activityTypeKey.String("charity")}},
+			want:   sdktrace.Drop,
+		},
+		{
+			name:   "root without ratio",
+			params: sdktrace.SamplingParameters{TraceID: testTraceID, Attributes: []attribute.KeyValue{activityTypeKey.String("music")}},
+			want:   sdktrace.RecordAndSample,
+		},
+		{
+			name: "sampled parent",
+			params: sdktrace.SamplingParameters{
+				TraceID:       testTraceID,
+				ParentContext: parentContext(true),
+				Attributes:    []attribute.KeyValue{activityTypeKey.String("charity")},
+			},
+			want: sdktrace.RecordAndSample,
+		},
+		{
+			name: "unsampled parent",
+			params: sdktrace.SamplingParameters{
+				TraceID:       testTraceID,
+				ParentContext: parentContext(false),
+				Attributes:    []attribute.KeyValue{activityTypeKey.String("music")},
+			},
+			want: sdktrace.Drop,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sampler.ShouldSample(tt.params).Decision; got != tt.want {
+				t.Errorf("decision = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActivityTypeMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ActivityTypeMiddleware())
+	var gotType, gotBody string
+	router.POST("/getActivity", func(c *gin.Context) {
+		gotType, _ = c.Request.Context().Value(activityTypeContextKey{}).(string)
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		gotBody = string(body)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/getActivity", strings.NewReader("type=charity"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotType != "charity" {
+		t.Errorf("activity type in context = %q, want charity", gotType)
+	}
+	if gotBody != "type=charity" {
+		t.Errorf("handler read body %q, want the original form", gotBody)
+	}
+}
+
+func TestDecisionRecordingSampler(t *testing.T) {
+	tests := []struct {
+		sampler  sdktrace.Sampler
+		decision string
+	}{
+		{sdktrace.AlwaysSample(), "RecordAndSample"},
+		{sdktrace.NeverSample(), "Drop"},
+	}
+	for _, tt := range tests {
+		result := decisionRecordingSampler{tt.sampler}.ShouldSample(sdktrace.SamplingParameters{TraceID: testTraceID, Name: "root"})
+		got := map[attribute.Key]string{}
+		for _, kv := range result.Attributes {
+			got[kv.Key] = kv.Value.AsString()
+		}
+		if got["sampling.decision"] != tt.decision {
+			t.Errorf("%s: sampling.decision = %q, want %q", tt.sampler.Description(), got["sampling.decision"], tt.decision)
+		}
+		if got["sampling.sampler"] != tt.sampler.Description() {
+			t.Errorf("%s: sampling.sampler = %q", tt.sampler.Description(), got["sampling.sampler"])
+		}
+	}
+
+	// Only root spans are annotated.
+	result := decisionRecordingSampler{sdktrace.AlwaysSample()}.ShouldSample(sdktrace.SamplingParameters{TraceID: testTraceID, ParentContext: parentContext(true)})
+	if len(result.Attributes) != 0 {
+		t.Errorf("child span got attributes %v", result.Attributes)
+	}
+}
+
+func TestLoggingSamplerLogsEachRootDecision(t *testing.T) {
+	logs := make(chanWriter, 10)
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	sampler := loggingSampler{sdktrace.NeverSample()}
+	sampler.ShouldSample(sdktrace.SamplingParameters{TraceID: testTraceID, Name: "/getActivity"})
+	sampler.ShouldSample(sdktrace.SamplingParameters{TraceID: testTraceID, Name: "/readyz"})
+	sampler.ShouldSample(sdktrace.SamplingParameters{TraceID: testTraceID, Name: "child", ParentContext: parentContext(true)})
+
+	for _, route := range []string{"/getActivity", "/readyz"} {
+		line := <-logs
+		want := fmt.Sprintf("trace_id=%s route=%q decision=Drop", testTraceID, route)
+		if !strings.Contains(line, want) {
+			t.Errorf("logged %q, want %s", line, want)
+		}
+	}
+	select {
+	case line := <-logs:
+		t.Errorf("child span was logged: %q", line)
+	default:
+	}
+}
+
+func TestWarmupSampler(t *testing.T) {
+	// The ratio sampler looks at the high 8 bytes of the trace ID; with 0xff
+	// leading them it drops every trace.
+	traceID := func(i int) oteltrace.TraceID { return oteltrace.TraceID{0xff, 15: byte(i)} }
+	sampler := newWarmupSampler(sdktrace.TraceIDRatioBased(0.5), 3)
+	for i := 0; i < 10; i++ {
+		want := sdktrace.Drop
+		if i < 3 {
+			want = sdktrace.RecordAndSample
+		}
+		if got := sampler.ShouldSample(sdktrace.SamplingParameters{TraceID: traceID(i)}).Decision; got != want {
+			t.Errorf("trace %d: decision = %s, want %s", i, decisionName(got), decisionName(want))
+		}
+	}
+
+	// Later spans in a warmup trace are kept too.
+	child := sdktrace.SamplingParameters{
+		TraceID:       traceID(0),
+		ParentContext: oteltrace.SpanContext{TraceID: traceID(0), SpanID: testSpanID},
+	}
+	if got := sampler.ShouldSample(child).Decision; got != sdktrace.RecordAndSample {
+		t.Errorf("child of a warmup trace: decision = %s, want %s", decisionName(got), decisionName(sdktrace.RecordAndSample))
+	}
+}
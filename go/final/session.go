@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const sessionIDKey = attribute.Key("session.id")
+
+type sessionContextKey struct{}
+
+// SessionMiddleware stores the X-Session-ID header in the request context,
+// so workshop attendees can find their own traces. It must run before otelgin
+// so the server span carries the ID too.
+func SessionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id := c.GetHeader("X-Session-ID"); id != "" {
+			c.Request = c.Request.WithContext(withSessionID(c.Request.Context(), id))
+		}
+		c.Next()
+	}
+}
+
+func withSessionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, id)
+}
+
+func sessionIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(sessionContextKey{}).(string)
+	return id, ok
+}
+
+// sessionProcessor sets session.id on every span started in a context that
+// carries one.
+type sessionProcessor struct{}
+
+func (sessionProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	if id, ok := sessionIDFromContext(parent); ok {
+		s.SetAttributes(sessionIDKey.String(id))
+	}
+}
+
+func (sessionProcessor) OnEnd(sdktrace.ReadOnlySpan)    {}
+func (sessionProcessor) Shutdown(context.Context) error { return nil }
+func (sessionProcessor) ForceFlush()                    {}
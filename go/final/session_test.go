@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+func TestSessionIDOnEverySpan(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	provider, recorder := NewTestProvider()
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	router := gin.New()
+	router.Use(SessionMiddleware())
+	router.Use(otelgin.Middleware("go-server", otelgin.WithTracerProvider(provider)))
+	router.POST("/getActivity", handleForm)
+
+	req := formRequest("session-a")
+	req.Header.Set("X-Session-ID", "whiskers-42")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	spans := recorder.Ended()
+	if len(spans) < 3 {
+		t.Fatalf("got %d spans, want the server span and its children", len(spans))
+	}
+	for _, span := range spans {
+		if v, _ := spanAttr(span, sessionIDKey); v.AsString() != "whiskers-42" {
+			t.Errorf("span %q: session.id = %q, want %q", span.Name(), v.AsString(), "whiskers-42")
+		}
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), formRequest("session-b"))
+	for _, span := range recorder.Ended()[len(spans):] {
+		if _, found := spanAttr(span, sessionIDKey); found {
+			t.Errorf("span %q has session.id without an X-Session-ID header", span.Name())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+)
+
+// NewOTLPDriver returns the OTLP driver for protocol, one of grpc,
+// http/protobuf or http/json. An empty endpoint means the local collector's
+// default port for the protocol. gRPC endpoints may be host:port, a URL or a
+// unix:// socket path.
+func NewOTLPDriver(protocol, endpoint string) (otlp.ProtocolDriver, error) {
+	switch protocol {
+	case "grpc":
+		if endpoint == "" {
+			endpoint = "localhost:4317"
+		}
+		// Reconnect with exponential backoff if the collector goes away,
+		// rather than failing exports until the next dial.
+		opts := []otlpgrpc.Option{
+			otlpgrpc.WithInsecure(),
+			otlpgrpc.WithReconnectionPeriod(5 * time.Second),
+			otlpgrpc.WithDialOption(grpc.WithConnectParams(grpc.ConnectParams{
+				Backoff:           backoff.Config{BaseDelay: time.Second, Multiplier: 1.6, Jitter: 0.2, MaxDelay: 30 * time.Second},
+				MinConnectTimeout: 5 * time.Second,
+			})),
+		}
+		if path, ok := unixSocketPath(endpoint); ok {
+			return otlpgrpc.NewDriver(append(opts,
+				otlpgrpc.WithEndpoint(endpoint),
+				otlpgrpc.WithDialOption(grpc.WithContextDialer(
+					func(ctx context.Context, _ string) (net.Conn, error) {
+						var d net.Dialer
+						return d.DialContext(ctx, "unix", path)
+					},
+				)),
+			)...), nil
+		}
+		// The gRPC exporter wants host:port, but the spec's environment
+		// variables are URLs.
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			endpoint = u.Host
+		}
+		return otlpgrpc.NewDriver(append(opts, otlpgrpc.WithEndpoint(endpoint))...), nil
+	case "http/json", "http/protobuf":
+		host, path, insecure := "localhost:4318", "/v1/traces", true
+		if endpoint != "" {
+			var err error
+			if host, path, insecure, err = splitHTTPEndpoint(endpoint); err != nil {
+				return nil, err
+			}
+		}
+		if protocol == "http/json" {
+			return newJSONDriver(host, path, insecure), nil
+		}
+		opts := []otlphttp.Option{
+			otlphttp.WithEndpoint(host),
+			otlphttp.WithTracesURLPath(path),
+		}
+		if insecure {
+			opts = append(opts, otlphttp.WithInsecure())
+		}
+		return otlphttp.NewDriver(opts...), nil
+	}
+	return nil, fmt.Errorf("unknown OTLP protocol %q", protocol)
+}
+
+// splitHTTPEndpoint breaks an OTLP/HTTP endpoint into the pieces the
+// exporter is configured with. Bare host:port endpoints use plain HTTP and
+// the default traces path.
+func splitHTTPEndpoint(endpoint string) (host, path string, insecure bool, err error) {
+	if !strings.Contains(endpoint, "://") {
+		return endpoint, "/v1/traces", true, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", false, fmt.Errorf("invalid OTLP endpoint %q: %w", endpoint, err)
+	}
+	path = u.Path
+	if path == "" || path == "/" {
+		path = "/v1/traces"
+	}
+	return u.Host, path, u.Scheme == "http", nil
+}
+
+// unixSocketPath returns the socket path from an endpoint like
+// unix:///var/run/otel.sock.
+func unixSocketPath(endpoint string) (string, bool) {
+	if !strings.HasPrefix(endpoint, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(endpoint, "unix://"), true
+}
@@ -0,0 +1,14 @@
+package telemetry
+
+import "testing"
+
+func TestNewOTLPDriverProtocols(t *testing.T) {
+	for _, protocol := range []string{"grpc", "http/protobuf", "http/json"} {
+		if _, err := NewOTLPDriver(protocol, ""); err != nil {
+			t.Errorf("NewOTLPDriver(%q): %v", protocol, err)
+		}
+	}
+	if _, err := NewOTLPDriver("carrier-pigeon", ""); err == nil {
+		t.Error("NewOTLPDriver accepted an unknown protocol")
+	}
+}
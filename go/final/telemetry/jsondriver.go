@@ -0,0 +1,556 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/metric/number"
+	exportmetric "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// jsonDriver sends OTLP/HTTP requests with JSON bodies. The OTLP/HTTP driver
+// in this version of the exporter only speaks protobuf and keeps its message
+// types internal, so the JSON encoding is built here. As the OTLP spec asks,
+// trace and span IDs are hex strings, 64-bit integers are strings and enums
+// are numbers.
+type jsonDriver struct {
+	client     *http.Client
+	tracesURL  string
+	metricsURL string
+}
+
+var _ otlp.ProtocolDriver = (*jsonDriver)(nil)
+
+func newJSONDriver(host, tracesPath string, insecure bool) *jsonDriver {
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	return &jsonDriver{
+		client:     &http.Client{Transport: http.DefaultTransport},
+		tracesURL:  scheme + "://" + host + tracesPath,
+		metricsURL: scheme + "://" + host + "/v1/metrics",
+	}
+}
+
+func (d *jsonDriver) Start(context.Context) error { return nil }
+
+func (d *jsonDriver) Stop(context.Context) error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+func (d *jsonDriver) ExportTraces(ctx context.Context, spans []*exporttrace.SpanSnapshot) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	return d.send(ctx, d.tracesURL, jsonTracesRequest{ResourceSpans: resourceSpans(spans)})
+}
+
+func (d *jsonDriver) ExportMetrics(ctx context.Context, cps exportmetric.CheckpointSet, selector exportmetric.ExportKindSelector) error {
+	metrics, err := resourceMetrics(cps, selector)
+	if err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return d.send(ctx, d.metricsURL, jsonMetricsRequest{ResourceMetrics: metrics})
+}
+
+func (d *jsonDriver) send(ctx context.Context, url string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP/JSON export to %s failed: %s", url, res.Status)
+	}
+	return nil
+}
+
+type jsonTracesRequest struct {
+	ResourceSpans []*jsonResourceSpans `json:"resourceSpans"`
+}
+
+type jsonResourceSpans struct {
+	Resource                    jsonResource                   `json:"resource"`
+	InstrumentationLibrarySpans []*jsonInstrumentationLibSpans `json:"instrumentationLibrarySpans"`
+}
+
+type jsonInstrumentationLibSpans struct {
+	InstrumentationLibrary jsonInstrumentationLib `json:"instrumentationLibrary"`
+	Spans                  []jsonSpan             `json:"spans"`
+}
+
+type jsonResource struct {
+	Attributes []jsonKeyValue `json:"attributes,omitempty"`
+}
+
+type jsonInstrumentationLib struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type jsonSpan struct {
+	TraceID                string          `json:"traceId"`
+	SpanID                 string          `json:"spanId"`
+	TraceState             string          `json:"traceState,omitempty"`
+	ParentSpanID           string          `json:"parentSpanId,omitempty"`
+	Name                   string          `json:"name"`
+	Kind                   int             `json:"kind"`
+	StartTimeUnixNano      string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano        string          `json:"endTimeUnixNano"`
+	Attributes             []jsonKeyValue  `json:"attributes,omitempty"`
+	DroppedAttributesCount int             `json:"droppedAttributesCount,omitempty"`
+	Events                 []jsonSpanEvent `json:"events,omitempty"`
+	DroppedEventsCount     int             `json:"droppedEventsCount,omitempty"`
+	Links                  []jsonSpanLink  `json:"links,omitempty"`
+	DroppedLinksCount      int             `json:"droppedLinksCount,omitempty"`
+	Status                 jsonStatus      `json:"status"`
+}
+
+type jsonSpanEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []jsonKeyValue `json:"attributes,omitempty"`
+}
+
+type jsonSpanLink struct {
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	TraceState string         `json:"traceState,omitempty"`
+	Attributes []jsonKeyValue `json:"attributes,omitempty"`
+}
+
+type jsonStatus struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
+
+type jsonKeyValue struct {
+	Key   string       `json:"key"`
+	Value jsonAnyValue `json:"value"`
+}
+
+type jsonAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *jsonArrayValue `json:"arrayValue,omitempty"`
+}
+
+type jsonArrayValue struct {
+	Values []jsonAnyValue `json:"values"`
+}
+
+// Span kinds and status codes as numbered in the OTLP protos.
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+var otlpSpanKinds = map[oteltrace.SpanKind]int{
+	oteltrace.SpanKindInternal: 1,
+	oteltrace.SpanKindServer:   2,
+	oteltrace.SpanKindClient:   3,
+	oteltrace.SpanKindProducer: 4,
+	oteltrace.SpanKindConsumer: 5,
+}
+
+// resourceSpans groups spans by resource and then by instrumentation
+// library, keeping the order they were first seen in.
+func resourceSpans(spans []*exporttrace.SpanSnapshot) []*jsonResourceSpans {
+	type libKey struct {
+		res attribute.Distinct
+		lib instrumentation.Library
+	}
+	byResource := map[attribute.Distinct]*jsonResourceSpans{}
+	byLib := map[libKey]*jsonInstrumentationLibSpans{}
+	var out []*jsonResourceSpans
+	for _, s := range spans {
+		if s == nil {
+			continue
+		}
+		rs, ok := byResource[s.Resource.Equivalent()]
+		if !ok {
+			rs = &jsonResourceSpans{Resource: jsonResourceOf(s.Resource)}
+			byResource[s.Resource.Equivalent()] = rs
+			out = append(out, rs)
+		}
+		key := libKey{s.Resource.Equivalent(), s.InstrumentationLibrary}
+		ils, ok := byLib[key]
+		if !ok {
+			ils = &jsonInstrumentationLibSpans{InstrumentationLibrary: jsonInstrumentationLib{
+				Name:    s.InstrumentationLibrary.Name,
+				Version: s.InstrumentationLibrary.Version,
+			}}
+			byLib[key] = ils
+			rs.InstrumentationLibrarySpans = append(rs.InstrumentationLibrarySpans, ils)
+		}
+		ils.Spans = append(ils.Spans, jsonSpanOf(s))
+	}
+	return out
+}
+
+func jsonSpanOf(s *exporttrace.SpanSnapshot) jsonSpan {
+	span := jsonSpan{
+		TraceID:                s.SpanContext.TraceID.String(),
+		SpanID:                 s.SpanContext.SpanID.String(),
+		TraceState:             s.SpanContext.TraceState.String(),
+		Name:                   s.Name,
+		Kind:                   otlpSpanKinds[s.SpanKind],
+		StartTimeUnixNano:      unixNano(s.StartTime),
+		EndTimeUnixNano:        unixNano(s.EndTime),
+		Attributes:             jsonAttributes(s.Attributes),
+		DroppedAttributesCount: s.DroppedAttributeCount,
+		DroppedEventsCount:     s.DroppedMessageEventCount,
+		DroppedLinksCount:      s.DroppedLinkCount,
+		Status:                 jsonStatus{Message: s.StatusMessage, Code: otlpStatusOK},
+	}
+	if s.ParentSpanID.IsValid() {
+		span.ParentSpanID = s.ParentSpanID.String()
+	}
+	if s.StatusCode == codes.Error {
+		span.Status.Code = otlpStatusError
+	}
+	for _, e := range s.MessageEvents {
+		span.Events = append(span.Events, jsonSpanEvent{
+			TimeUnixNano: unixNano(e.Time),
+			Name:         e.Name,
+			Attributes:   jsonAttributes(e.Attributes),
+		})
+	}
+	for _, l := range s.Links {
+		span.Links = append(span.Links, jsonSpanLink{
+			TraceID:    l.TraceID.String(),
+			SpanID:     l.SpanID.String(),
+			TraceState: l.TraceState.String(),
+			Attributes: jsonAttributes(l.Attributes),
+		})
+	}
+	return span
+}
+
+func jsonResourceOf(res *resource.Resource) jsonResource {
+	return jsonResource{Attributes: jsonAttributes(res.Attributes())}
+}
+
+func jsonAttributes(attrs []attribute.KeyValue) []jsonKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make([]jsonKeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		out = append(out, jsonKeyValue{Key: string(kv.Key), Value: jsonValue(kv.Value.AsInterface())})
+	}
+	return out
+}
+
+// jsonValue encodes an attribute value as an OTLP AnyValue. Arrays are
+// stored as Go arrays of the element types attribute.Array accepts.
+func jsonValue(v interface{}) jsonAnyValue {
+	switch v := v.(type) {
+	case bool:
+		return jsonAnyValue{BoolValue: &v}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return jsonAnyValue{IntValue: &s}
+	case float64:
+		return jsonAnyValue{DoubleValue: &v}
+	case string:
+		return jsonAnyValue{StringValue: &v}
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Array && rv.Kind() != reflect.Slice {
+		s := fmt.Sprint(v)
+		return jsonAnyValue{StringValue: &s}
+	}
+	values := make([]jsonAnyValue, rv.Len())
+	for i := range values {
+		switch e := rv.Index(i); e.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			values[i] = jsonValue(e.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			values[i] = jsonValue(int64(e.Uint()))
+		case reflect.Float32, reflect.Float64:
+			values[i] = jsonValue(e.Float())
+		default:
+			values[i] = jsonValue(e.Interface())
+		}
+	}
+	return jsonAnyValue{ArrayValue: &jsonArrayValue{Values: values}}
+}
+
+func unixNano(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+type jsonMetricsRequest struct {
+	ResourceMetrics []*jsonResourceMetrics `json:"resourceMetrics"`
+}
+
+type jsonResourceMetrics struct {
+	Resource                      jsonResource                     `json:"resource"`
+	InstrumentationLibraryMetrics []*jsonInstrumentationLibMetrics `json:"instrumentationLibraryMetrics"`
+}
+
+type jsonInstrumentationLibMetrics struct {
+	InstrumentationLibrary jsonInstrumentationLib `json:"instrumentationLibrary"`
+	Metrics                []jsonMetric           `json:"metrics"`
+}
+
+// jsonMetric holds exactly one of its data fields, chosen by the
+// aggregation and whether the instrument records integers or floats.
+type jsonMetric struct {
+	Name            string         `json:"name"`
+	Description     string         `json:"description,omitempty"`
+	Unit            string         `json:"unit,omitempty"`
+	IntGauge        *jsonGauge     `json:"intGauge,omitempty"`
+	DoubleGauge     *jsonGauge     `json:"doubleGauge,omitempty"`
+	IntSum          *jsonSum       `json:"intSum,omitempty"`
+	DoubleSum       *jsonSum       `json:"doubleSum,omitempty"`
+	IntHistogram    *jsonHistogram `json:"intHistogram,omitempty"`
+	DoubleHistogram *jsonHistogram `json:"doubleHistogram,omitempty"`
+}
+
+type jsonGauge struct {
+	DataPoints []jsonDataPoint `json:"dataPoints"`
+}
+
+type jsonSum struct {
+	DataPoints             []jsonDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type jsonHistogram struct {
+	DataPoints             []jsonHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+// jsonDataPoint's Value is a string for integer points and a float64 for
+// double points.
+type jsonDataPoint struct {
+	Labels            []jsonStringKeyValue `json:"labels,omitempty"`
+	StartTimeUnixNano string               `json:"startTimeUnixNano"`
+	TimeUnixNano      string               `json:"timeUnixNano"`
+	Value             interface{}          `json:"value"`
+}
+
+type jsonHistogramDataPoint struct {
+	Labels            []jsonStringKeyValue `json:"labels,omitempty"`
+	StartTimeUnixNano string               `json:"startTimeUnixNano"`
+	TimeUnixNano      string               `json:"timeUnixNano"`
+	Count             string               `json:"count"`
+	Sum               interface{}          `json:"sum"`
+	BucketCounts      []string             `json:"bucketCounts,omitempty"`
+	ExplicitBounds    []float64            `json:"explicitBounds,omitempty"`
+}
+
+type jsonStringKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Aggregation temporalities as numbered in the OTLP protos.
+var otlpTemporality = map[exportmetric.ExportKind]int{
+	exportmetric.DeltaExportKind:      1,
+	exportmetric.CumulativeExportKind: 2,
+}
+
+// resourceMetrics groups the checkpointed records by resource and then by
+// instrumentation library.
+func resourceMetrics(cps exportmetric.CheckpointSet, selector exportmetric.ExportKindSelector) ([]*jsonResourceMetrics, error) {
+	type libKey struct {
+		res  attribute.Distinct
+		name string
+	}
+	byResource := map[attribute.Distinct]*jsonResourceMetrics{}
+	byLib := map[libKey]*jsonInstrumentationLibMetrics{}
+	var out []*jsonResourceMetrics
+	err := cps.ForEach(selector, func(r exportmetric.Record) error {
+		m, err := jsonMetricOf(r, selector)
+		if err != nil {
+			return err
+		}
+		res := r.Resource()
+		rm, ok := byResource[res.Equivalent()]
+		if !ok {
+			rm = &jsonResourceMetrics{Resource: jsonResourceOf(res)}
+			byResource[res.Equivalent()] = rm
+			out = append(out, rm)
+		}
+		desc := r.Descriptor()
+		key := libKey{res.Equivalent(), desc.InstrumentationName()}
+		ilm, ok := byLib[key]
+		if !ok {
+			ilm = &jsonInstrumentationLibMetrics{InstrumentationLibrary: jsonInstrumentationLib{
+				Name:    desc.InstrumentationName(),
+				Version: desc.InstrumentationVersion(),
+			}}
+			byLib[key] = ilm
+			rm.InstrumentationLibraryMetrics = append(rm.InstrumentationLibraryMetrics, ilm)
+		}
+		ilm.Metrics = append(ilm.Metrics, m)
+		return nil
+	})
+	return out, err
+}
+
+func jsonMetricOf(r exportmetric.Record, selector exportmetric.ExportKindSelector) (jsonMetric, error) {
+	desc := r.Descriptor()
+	kind := desc.NumberKind()
+	m := jsonMetric{Name: desc.Name(), Description: desc.Description(), Unit: string(desc.Unit())}
+	labels := jsonLabels(r.Labels())
+	agg := r.Aggregation()
+	switch a := agg.(type) {
+	case aggregation.Histogram:
+		buckets, err := a.Histogram()
+		if err != nil {
+			return m, err
+		}
+		point, err := histogramPoint(a, kind, labels, r.StartTime(), r.EndTime())
+		if err != nil {
+			return m, err
+		}
+		for _, c := range buckets.Counts {
+			point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(c, 10))
+		}
+		point.ExplicitBounds = buckets.Boundaries
+		h := &jsonHistogram{
+			DataPoints:             []jsonHistogramDataPoint{point},
+			AggregationTemporality: otlpTemporality[selector.ExportKindFor(desc, aggregation.HistogramKind)],
+		}
+		if kind == number.Int64Kind {
+			m.IntHistogram = h
+		} else {
+			m.DoubleHistogram = h
+		}
+	case aggregation.MinMaxSumCount:
+		// Exported as a histogram with a single bucket, as the OTLP/gRPC
+		// exporter does.
+		point, err := histogramPoint(a, kind, labels, r.StartTime(), r.EndTime())
+		if err != nil {
+			return m, err
+		}
+		h := &jsonHistogram{DataPoints: []jsonHistogramDataPoint{point}}
+		if kind == number.Int64Kind {
+			m.IntHistogram = h
+		} else {
+			m.DoubleHistogram = h
+		}
+	case aggregation.Sum:
+		sum, err := a.Sum()
+		if err != nil {
+			return m, err
+		}
+		s := &jsonSum{
+			DataPoints: []jsonDataPoint{{
+				Labels:            labels,
+				StartTimeUnixNano: unixNano(r.StartTime()),
+				TimeUnixNano:      unixNano(r.EndTime()),
+				Value:             jsonNumber(sum, kind),
+			}},
+			AggregationTemporality: otlpTemporality[selector.ExportKindFor(desc, aggregation.SumKind)],
+			IsMonotonic:            desc.InstrumentKind().Monotonic(),
+		}
+		if kind == number.Int64Kind {
+			m.IntSum = s
+		} else {
+			m.DoubleSum = s
+		}
+	case aggregation.LastValue:
+		value, at, err := a.LastValue()
+		if err != nil {
+			return m, err
+		}
+		g := &jsonGauge{DataPoints: []jsonDataPoint{{
+			Labels:            labels,
+			StartTimeUnixNano: "0",
+			TimeUnixNano:      unixNano(at),
+			Value:             jsonNumber(value, kind),
+		}}}
+		if kind == number.Int64Kind {
+			m.IntGauge = g
+		} else {
+			m.DoubleGauge = g
+		}
+	default:
+		return m, fmt.Errorf("OTLP/JSON export doesn't support %s aggregation for %s", agg.Kind(), desc.Name())
+	}
+	return m, nil
+}
+
+// countSum is what histograms and MinMaxSumCount aggregations have in
+// common.
+type countSum interface {
+	Count() (uint64, error)
+	Sum() (number.Number, error)
+}
+
+func histogramPoint(a countSum, kind number.Kind, labels []jsonStringKeyValue, start, end time.Time) (jsonHistogramDataPoint, error) {
+	point := jsonHistogramDataPoint{
+		Labels:            labels,
+		StartTimeUnixNano: unixNano(start),
+		TimeUnixNano:      unixNano(end),
+	}
+	count, err := a.Count()
+	if err != nil {
+		return point, err
+	}
+	sum, err := a.Sum()
+	if err != nil {
+		return point, err
+	}
+	point.Count = strconv.FormatUint(count, 10)
+	point.Sum = jsonNumber(sum, kind)
+	return point, nil
+}
+
+func jsonNumber(n number.Number, kind number.Kind) interface{} {
+	if kind == number.Int64Kind {
+		return strconv.FormatInt(n.AsInt64(), 10)
+	}
+	return n.AsFloat64()
+}
+
+func jsonLabels(labels *attribute.Set) []jsonStringKeyValue {
+	var out []jsonStringKeyValue
+	for iter := labels.Iter(); iter.Next(); {
+		kv := iter.Attribute()
+		out = append(out, jsonStringKeyValue{Key: string(kv.Key), Value: kv.Value.Emit()})
+	}
+	return out
+}
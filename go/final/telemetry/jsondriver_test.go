@@ -0,0 +1,184 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// jsonCollector records the bodies of the OTLP/JSON requests it receives,
+// by path.
+type jsonCollector struct {
+	mu     sync.Mutex
+	bodies map[string][]map[string]interface{}
+}
+
+func startJSONCollector(t *testing.T) (*jsonCollector, otlp.ProtocolDriver) {
+	c := &jsonCollector{bodies: map[string][]map[string]interface{}{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("invalid JSON body: %v", err)
+		}
+		c.mu.Lock()
+		c.bodies[r.URL.Path] = append(c.bodies[r.URL.Path], body)
+		c.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	driver, err := NewOTLPDriver("http/json", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, driver
+}
+
+func (c *jsonCollector) received(path string) []map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bodies[path]
+}
+
+// field walks a decoded JSON body along path, where numbers index arrays.
+func field(v interface{}, path ...interface{}) interface{} {
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			m, _ := v.(map[string]interface{})
+			v = m[p]
+		case int:
+			a, _ := v.([]interface{})
+			if p >= len(a) {
+				return nil
+			}
+			v = a[p]
+		}
+	}
+	return v
+}
+
+func TestJSONDriverExportsSpans(t *testing.T) {
+	collector, driver := startJSONCollector(t)
+	ctx := context.Background()
+	exporter, err := otlp.NewExporter(ctx, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := provider.Tracer("cats").Start(ctx, "feed",
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(
+			attribute.Int64("cats.count", 42),
+			attribute.Array("cats.names", []string{"tabby", "tom"}),
+		),
+	)
+	span.AddEvent("bowl.empty")
+	span.RecordError(errors.New("out of kibble"))
+	span.SetStatus(codes.Error, "out of kibble")
+	span.End()
+	if err := provider.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := collector.received("/v1/traces")
+	if len(bodies) != 1 {
+		t.Fatalf("got %d trace requests, want 1", len(bodies))
+	}
+	got := field(bodies[0], "resourceSpans", 0, "instrumentationLibrarySpans", 0)
+	if name := field(got, "instrumentationLibrary", "name"); name != "cats" {
+		t.Errorf("instrumentation library = %v, want cats", name)
+	}
+	s := field(got, "spans", 0)
+	sc := span.SpanContext()
+	if id := field(s, "traceId"); id != sc.TraceID.String() {
+		t.Errorf("traceId = %v, want hex %s", id, sc.TraceID)
+	}
+	if id := field(s, "spanId"); id != sc.SpanID.String() {
+		t.Errorf("spanId = %v, want hex %s", id, sc.SpanID)
+	}
+	if kind := field(s, "kind"); kind != 3.0 {
+		t.Errorf("kind = %v, want 3 (client)", kind)
+	}
+	if code := field(s, "status", "code"); code != 2.0 {
+		t.Errorf("status code = %v, want 2 (error)", code)
+	}
+	if _, ok := field(s, "startTimeUnixNano").(string); !ok {
+		t.Errorf("startTimeUnixNano = %v, want a string", field(s, "startTimeUnixNano"))
+	}
+	attrs := map[string]interface{}{}
+	for _, kv := range field(s, "attributes").([]interface{}) {
+		attrs[field(kv, "key").(string)] = field(kv, "value")
+	}
+	if v := field(attrs["cats.count"], "intValue"); v != "42" {
+		t.Errorf("cats.count intValue = %v, want \"42\"", v)
+	}
+	if v := field(attrs["cats.names"], "arrayValue", "values", 1, "stringValue"); v != "tom" {
+		t.Errorf("cats.names[1] = %v, want tom", v)
+	}
+	if name := field(s, "events", 0, "name"); name != "bowl.empty" {
+		t.Errorf("first event = %v, want bowl.empty", name)
+	}
+}
+
+func TestJSONDriverExportsMetrics(t *testing.T) {
+	collector, driver := startJSONCollector(t)
+	ctx := context.Background()
+	exporter, err := otlp.NewExporter(ctx, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pusher := controller.New(
+		processor.New(simple.NewWithInexpensiveDistribution(), exporter),
+		controller.WithPusher(exporter),
+	)
+	if err := pusher.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	meter := metric.Must(pusher.MeterProvider().Meter("cats"))
+	meter.NewInt64Counter("cats.fed").Add(ctx, 3, attribute.String("cat", "tabby"))
+	meter.NewFloat64ValueRecorder("cats.nap_hours").Record(ctx, 1.5)
+	if err := pusher.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := collector.received("/v1/metrics")
+	if len(bodies) == 0 {
+		t.Fatal("no metric requests")
+	}
+	metrics := map[string]interface{}{}
+	for _, m := range field(bodies[0], "resourceMetrics", 0, "instrumentationLibraryMetrics", 0, "metrics").([]interface{}) {
+		metrics[field(m, "name").(string)] = m
+	}
+	fed := field(metrics["cats.fed"], "intSum")
+	if v := field(fed, "dataPoints", 0, "value"); v != "3" {
+		t.Errorf("cats.fed value = %v, want \"3\"", v)
+	}
+	if label := field(fed, "dataPoints", 0, "labels", 0, "value"); label != "tabby" {
+		t.Errorf("cats.fed cat label = %v, want tabby", label)
+	}
+	if monotonic := field(fed, "isMonotonic"); monotonic != true {
+		t.Errorf("cats.fed isMonotonic = %v, want true", monotonic)
+	}
+	naps := field(metrics["cats.nap_hours"], "doubleHistogram", "dataPoints", 0)
+	if count, sum := field(naps, "count"), field(naps, "sum"); count != "1" || sum != 1.5 {
+		t.Errorf("cats.nap_hours count, sum = %v, %v, want \"1\", 1.5", count, sum)
+	}
+}
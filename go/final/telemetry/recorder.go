@@ -0,0 +1,36 @@
+package telemetry
+
+import (
+	"context"
+	"sync"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// SpanRecorder is a span processor that keeps every span that ends, in the
+// order they ended.
+type SpanRecorder struct {
+	mu    sync.Mutex
+	ended []sdktrace.ReadOnlySpan
+}
+
+func (r *SpanRecorder) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (r *SpanRecorder) OnEnd(s sdktrace.ReadOnlySpan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ended = append(r.ended, s)
+}
+
+func (r *SpanRecorder) Shutdown(context.Context) error { return nil }
+
+func (r *SpanRecorder) ForceFlush() {}
+
+// Ended returns the spans recorded so far.
+func (r *SpanRecorder) Ended() []sdktrace.ReadOnlySpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ended := make([]sdktrace.ReadOnlySpan, len(r.ended))
+	copy(ended, r.ended)
+	return ended
+}
@@ -0,0 +1,54 @@
+// Package telemetry holds the OpenTelemetry setup shared by the go-server
+// and the commands under cmd.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+// Propagator returns the W3C trace context and baggage propagator.
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// Init creates a tracer provider for serviceName that samples every span and
+// exports them in batches over OTLP/gRPC to COLLECTOR_ENDPOINT, or the local
+// collector if it isn't set. The provider and Propagator are installed
+// globally.
+func Init(ctx context.Context, serviceName string) (*sdktrace.TracerProvider, error) {
+	driver, err := NewOTLPDriver("grpc", os.Getenv("COLLECTOR_ENDPOINT"))
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlp.NewExporter(ctx, driver)
+	if err != nil {
+		return nil, fmt.Errorf("creating collector exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(5*time.Second)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(Propagator())
+	return provider, nil
+}
@@ -0,0 +1,24 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestInitInstallsProvider(t *testing.T) {
+	t.Setenv("COLLECTOR_ENDPOINT", "localhost:0")
+	ctx := context.Background()
+	provider, err := Init(ctx, "go-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Shutdown(ctx)
+	if otel.GetTracerProvider() != provider {
+		t.Error("Init didn't install its tracer provider globally")
+	}
+	if fields := otel.GetTextMapPropagator().Fields(); len(fields) != 3 {
+		t.Errorf("propagator fields = %v, want traceparent, tracestate and baggage", fields)
+	}
+}
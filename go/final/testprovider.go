@@ -0,0 +1,23 @@
+package main
+
+import (
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"final/telemetry"
+)
+
+// NewTestProvider installs a global tracer provider that records spans in
+// memory rather than exporting them, so tests can assert on the spans a
+// request produces without a collector.
+func NewTestProvider() (*sdktrace.TracerProvider, *telemetry.SpanRecorder) {
+	recorder := &telemetry.SpanRecorder{}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithSpanProcessor(sessionProcessor{}),
+		sdktrace.WithSpanProcessor(recorder),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(telemetry.Propagator())
+	return provider, recorder
+}
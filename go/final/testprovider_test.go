@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestTestProviderRecordsGetActivitySpan(t *testing.T) {
+	_, recorder := NewTestProvider()
+	useUpstream(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return upstreamResponse(req, http.StatusOK, testActivityBody), nil
+	}))
+	if _, err := getActivityWithParams(context.Background(), "recreational"); err != nil {
+		t.Fatal(err)
+	}
+
+	span := endedSpan(t, recorder, "getActivityWithParams")
+	if span.SpanKind() != oteltrace.SpanKindClient {
+		t.Errorf("span kind = %v, want client", span.SpanKind())
+	}
+	want := map[attribute.Key]string{
+		"activityType":                 "recreational",
+		"activity.participants_bucket": "1",
+		"activity.price_tier":          "free",
+		"http.url":                     "https://www.boredapi.com/api/activity?type=recreational",
+	}
+	for key, value := range want {
+		if got, _ := spanAttr(span, key); got.Emit() != value {
+			t.Errorf("%s = %q, want %q", key, got.Emit(), value)
+		}
+	}
+	if v, _ := spanAttr(span, "activity.type_matched"); !v.AsBool() {
+		t.Error("activity.type_matched = false, want true")
+	}
+}
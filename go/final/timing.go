@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type serverTimingKey struct{}
+
+// serverTiming collects durations for the Server-Timing response header.
+type serverTiming struct {
+	mu      sync.Mutex
+	metrics []string
+}
+
+func withServerTiming(ctx context.Context) (context.Context, *serverTiming) {
+	t := &serverTiming{}
+	return context.WithValue(ctx, serverTimingKey{}, t), t
+}
+
+// recordTiming adds a metric to the Server-Timing collector in ctx, if there is one.
+func recordTiming(ctx context.Context, name string, d time.Duration) {
+	if t, ok := ctx.Value(serverTimingKey{}).(*serverTiming); ok {
+		t.add(name, d)
+	}
+}
+
+func (t *serverTiming) add(name string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.metrics = append(t.metrics, fmt.Sprintf("%s;dur=%.3f", name, float64(d)/float64(time.Millisecond)))
+}
+
+func (t *serverTiming) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.Join(t.metrics, ", ")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const defaultUpstreamBaseURL = "https://www.boredapi.com"
+
+// activityRoutes maps activity types to the base URL of the service that
+// should handle them, from the ACTIVITY_ROUTES JSON object. Types without a
+// route go to the Bored API.
+var activityRoutes = loadActivityRoutes()
+
+func loadActivityRoutes() map[string]string {
+	routes := map[string]string{}
+	if v, ok := os.LookupEnv("ACTIVITY_ROUTES"); ok {
+		if err := json.Unmarshal([]byte(v), &routes); err != nil {
+			log.Printf("Ignoring invalid ACTIVITY_ROUTES: %v", err)
+			return map[string]string{}
+		}
+	}
+	return routes
+}
+
+func upstreamBaseURL(t string) string {
+	if base, ok := activityRoutes[t]; ok {
+		return strings.TrimSuffix(base, "/")
+	}
+	return defaultUpstreamBaseURL
+}
+
+// upstreamService names the service behind a base URL for use as an attribute.
+func upstreamService(base string) string {
+	u, err := url.Parse(base)
+	if err != nil || u.Host == "" {
+		return base
+	}
+	return u.Host
+}
+
+// upstreamFlight deduplicates concurrent fetches of the same upstream URL.
+var upstreamFlight singleflight.Group
+
+// sharedFetchTimeout bounds a fetch shared through upstreamFlight, which no
+// single caller's deadline applies to.
+const sharedFetchTimeout = 30 * time.Second
+
+// sharedFetch is the result of a fetch shared through upstreamFlight.
+type sharedFetch struct {
+	body    []byte
+	retries int
+}
+
+// detachedContext keeps the values of the context it wraps, such as the
+// current span, but not its deadline or cancellation, so a fetch shared
+// between callers isn't cut short when the caller that started it goes away.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// redactedQueryParams are masked when upstream URLs are recorded on spans.
+var redactedQueryParams = loadRedactedQueryParams()
+
+func loadRedactedQueryParams() []string {
+	v, ok := os.LookupEnv("REDACTED_QUERY_PARAMS")
+	if !ok {
+		return []string{"api_key", "key", "token"}
+	}
+	return strings.Split(v, ",")
+}
+
+// sanitizeURL strips credentials from a URL and masks redacted query params.
+func sanitizeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	q := u.Query()
+	for _, key := range redactedQueryParams {
+		if key = strings.TrimSpace(key); key != "" && q.Has(key) {
+			q.Set(key, "REDACTED")
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// upstreamTransport is used for calls to the activity API. With
+// MOCK_UPSTREAM=true it's replaced by a canned responder, which is useful for
+// measuring instrumentation overhead without network noise.
+var upstreamTransport = newUpstreamTransport()
+
+func newUpstreamTransport() http.RoundTripper {
+	if os.Getenv("MOCK_UPSTREAM") != "true" {
+		// The dial timeout covers DNS resolution as well as connecting, so a
+		// slow resolver can't stall the upstream call indefinitely.
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{
+			Timeout:   upstreamDialTimeout(),
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		return transport
+	}
+	var delay time.Duration
+	if v, ok := os.LookupEnv("MOCK_UPSTREAM_DELAY"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("Ignoring invalid MOCK_UPSTREAM_DELAY %q", v)
+		}
+		delay = d
+	}
+	return mockUpstream{delay: delay}
+}
+
+func upstreamDialTimeout() time.Duration {
+	if v, ok := os.LookupEnv("UPSTREAM_DIAL_TIMEOUT"); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Ignoring invalid UPSTREAM_DIAL_TIMEOUT %q", v)
+	}
+	return 3 * time.Second
+}
+
+// isDialTimeout reports whether err is a connection attempt that timed out.
+func isDialTimeout(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout()
+}
+
+type mockUpstream struct {
+	delay time.Duration
+}
+
+func (m mockUpstream) RoundTrip(req *http.Request) (*http.Response, error) {
+	if m.delay > 0 {
+		select {
+		case <-time.After(m.delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	t := req.URL.Query().Get("type")
+	if t == "" {
+		t = "relaxation"
+	}
+	body := fmt.Sprintf(`{"activity":"Take a nap in a sunbeam","accessibility":0.1,"type":%q,"participants":1,"price":0}`, t)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// activityLogger appends fetched activities to a file in the background.
+// The work outlives the request that triggered it, so each write gets its
+// own trace with a link back to the request rather than a parent.
+type activityLogger struct {
+	jobs    chan activityLogJob
+	encoder *json.Encoder
+}
+
+type activityLogJob struct {
+	activity apiResponse
+	origin   oteltrace.SpanContext
+}
+
+var activityLog *activityLogger
+
+func startActivityLogger(path string) (*activityLogger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &activityLogger{jobs: make(chan activityLogJob, 100), encoder: json.NewEncoder(f)}
+	go l.run()
+	return l, nil
+}
+
+// enqueue schedules activity to be logged. It never blocks; if the queue is
+// full the activity is dropped.
+func (l *activityLogger) enqueue(ctx context.Context, activity apiResponse) {
+	if l == nil {
+		return
+	}
+	select {
+	case l.jobs <- activityLogJob{activity: activity, origin: oteltrace.SpanContextFromContext(ctx)}:
+	default:
+		oteltrace.SpanFromContext(ctx).AddEvent("activity log queue full")
+	}
+}
+
+func (l *activityLogger) run() {
+	for job := range l.jobs {
+		ctx, span := tracer().Start(context.Background(), "logActivity",
+			oteltrace.WithNewRoot(),
+			oteltrace.WithLinks(oteltrace.Link{SpanContext: job.origin}),
+		)
+		if err := l.encoder.Encode(job.activity); err != nil {
+			logError(ctx, "Failed to log activity: %v", err)
+		}
+		span.End()
+	}
+}
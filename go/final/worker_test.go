@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestActivityLoggerLinksToRequest(t *testing.T) {
+	provider, recorder := NewTestProvider()
+	logger, err := startActivityLogger(filepath.Join(t.TempDir(), "activities.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, request := provider.Tracer("test").Start(context.Background(), "request")
+	logger.enqueue(ctx, apiResponse{Activity: "Knock a glass off the table"})
+	request.End()
+
+	var worker sdktrace.ReadOnlySpan
+	for deadline := time.Now().Add(5 * time.Second); worker == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, span := range recorder.Ended() {
+			if span.Name() == "logActivity" {
+				worker = span
+			}
+		}
+	}
+	if worker == nil {
+		t.Fatal("no logActivity span recorded")
+	}
+	if worker.Parent().IsValid() {
+		t.Error("worker span has a parent, want a new root")
+	}
+	links := worker.Links()
+	if len(links) != 1 || links[0].SpanContext.SpanID != request.SpanContext().SpanID {
+		t.Errorf("worker links = %v, want one link to the request span", links)
+	}
+}